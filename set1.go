@@ -92,11 +92,11 @@ func breakSingleByteXOR(msg []byte, scoringFn func(s string) float32) ([]byte, b
 	dst := make([]byte, len(msg))
 	decodedMsg := make([]byte, len(msg))
 
-	for i := 0; i < 255; i++ {
+	for i := 0; i < 256; i++ {
 		b := byte(i)
 		SingleByteXOR(dst, msg, b)
 		msg := string(dst[:])
-		score := scoreEnglishText(msg)
+		score := scoringFn(msg)
 		if score > maxScore {
 			maxScore = score
 			copy(decodedMsg, dst)
@@ -122,8 +122,8 @@ func base64DecodeFile(fname string) ([]byte, error) {
 	}
 
 	EncryptedMsg := make([]byte, base64.StdEncoding.DecodedLen(len(encryptedFileBytes)))
-	_, err = base64.StdEncoding.Decode(EncryptedMsg, encryptedFileBytes)
-	return EncryptedMsg, err
+	n, err := base64.StdEncoding.Decode(EncryptedMsg, encryptedFileBytes)
+	return EncryptedMsg[:n], err
 
 }
 
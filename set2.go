@@ -5,12 +5,15 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	mrand "math/rand"
 	"strings"
-	"time"
 )
 
+// ErrInvalidPadding is returned when a msg is not properly PKCS#7 padded.
+var ErrInvalidPadding = errors.New("invalid pkcs7 padding")
+
 // Pkcs7Pad right-pads the given byte slice with 1 to n bytes, where
 // n is the block size. The size of the result is x times n, where x
 // is at least 1.
@@ -22,16 +25,26 @@ func Pkcs7Pad(src []byte, blockSize int) ([]byte, error) {
 	}
 
 	npad := blockSize - len(src)%blockSize
-	if npad < blockSize {
-		src = append(src, bytes.Repeat([]byte{byte(npad)}, npad)...)
-	}
+	src = append(src, bytes.Repeat([]byte{byte(npad)}, npad)...)
 	return src, nil
 }
 
-// Pkcs7Unpad remove the padding
-func Pkcs7Unpad(msg []byte) []byte {
-	fmt.Println("padded = ", int(msg[len(msg)-1]))
-	return msg[:len(msg)-int(msg[len(msg)-1])]
+// Pkcs7Unpad validates and removes the padding. ErrInvalidPadding is returned
+// when the padding is wrong.
+func Pkcs7Unpad(msg []byte, blockSize int) ([]byte, error) {
+	if blockSize < 1 || len(msg) == 0 || len(msg)%blockSize != 0 {
+		return nil, fmt.Errorf("%w: len(msg) = %d is not a multiple of %d", ErrInvalidPadding, len(msg), blockSize)
+	}
+	npad := int(msg[len(msg)-1])
+	if npad == 0 || npad > blockSize {
+		return nil, fmt.Errorf("%w: pad value %d out of range", ErrInvalidPadding, npad)
+	}
+	for _, b := range msg[len(msg)-npad:] {
+		if int(b) != npad {
+			return nil, fmt.Errorf("%w: inconsistent pad bytes", ErrInvalidPadding)
+		}
+	}
+	return msg[:len(msg)-npad], nil
 }
 
 //CBCDecrypter returns the decrypted message using the CBC mode
@@ -74,6 +87,21 @@ func CBCEncrypter(msg, iv []byte, c cipher.Block) []byte {
 	return encryptedMsg
 }
 
+// CBCPadEncrypt PKCS#7 pads then encrypts msg using the CBC mode
+func CBCPadEncrypt(msg, iv []byte, c cipher.Block) ([]byte, error) {
+	paddedMsg, err := Pkcs7Pad(append([]byte{}, msg...), c.BlockSize())
+	if err != nil {
+		return nil, err
+	}
+	return CBCEncrypter(paddedMsg, iv, c), nil
+}
+
+// CBCDecryptUnpad decrypts msg using the CBC mode and removes the PKCS#7
+// padding. ErrInvalidPadding is returned when the padding is wrong.
+func CBCDecryptUnpad(msg, iv []byte, c cipher.Block) ([]byte, error) {
+	return Pkcs7Unpad(CBCDecrypter(msg, iv, c), c.BlockSize())
+}
+
 // GenerateRandomBytes returns a random key of the specified size
 func GenerateRandomBytes(size int) ([]byte, error) {
 	key := make([]byte, size)
@@ -84,10 +112,10 @@ func GenerateRandomBytes(size int) ([]byte, error) {
 }
 
 func encryptionOracle(msg []byte) ([]byte, error) {
-	mrand.Seed(time.Now().Unix())
+	rng := mrand.New(NewMT19937Source(defaultClock.Now().Unix()))
 	// Generate random prefix and suffix
-	prefixLength := mrand.Intn(5)
-	suffixLength := mrand.Intn(5)
+	prefixLength := rng.Intn(5)
+	suffixLength := rng.Intn(5)
 
 	keySize := 16
 	rdm, err := GenerateRandomBytes((keySize * 2) + prefixLength + suffixLength)
@@ -106,7 +134,7 @@ func encryptionOracle(msg []byte) ([]byte, error) {
 	alteredMsg = append(alteredMsg, prefix...)
 	alteredMsg = append(alteredMsg, msg...)
 	alteredMsg = append(alteredMsg, suffix...)
-	coin := mrand.Intn(2)
+	coin := rng.Intn(2)
 	fmt.Println("coin =", coin)
 	var encryptedMsg []byte
 	if coin == 0 {
@@ -234,8 +262,10 @@ func NewProfileFromEncrypted(msg, key []byte) (Profile, error) {
 		return Profile{}, err
 	}
 
-	decryptedMsg := ECBDecrypter(msg, c)
-	decryptedMsg = Pkcs7Unpad(decryptedMsg)
+	decryptedMsg, err := Pkcs7Unpad(ECBDecrypter(msg, c), c.BlockSize())
+	if err != nil {
+		return Profile{}, err
+	}
 	p := Profile{}
 	for _, kv := range bytes.Split(decryptedMsg, []byte("&")) {
 		kvs := bytes.Split(kv, []byte("="))
@@ -253,3 +283,358 @@ func NewProfileFromEncrypted(msg, key []byte) (Profile, error) {
 	}
 	return p, nil
 }
+
+// newECBOracle returns an oracle encrypting prefix || msg || secret with AES in ECB mode.
+func newECBOracle(prefix, secret, key []byte) func([]byte) ([]byte, error) {
+	return func(msg []byte) ([]byte, error) {
+		plainMsg := make([]byte, 0, len(prefix)+len(msg)+len(secret))
+		plainMsg = append(plainMsg, prefix...)
+		plainMsg = append(plainMsg, msg...)
+		plainMsg = append(plainMsg, secret...)
+		return oracleAesECB(plainMsg, key)
+	}
+}
+
+// newRandomPrefixECBOracle returns an ECB oracle using a random key and a
+// random-length random prefix that stays the same for every call.
+func newRandomPrefixECBOracle(secret []byte) (func([]byte) ([]byte, error), error) {
+	key, err := GenerateRandomBytes(16)
+	if err != nil {
+		return nil, err
+	}
+	prefix, err := GenerateRandomBytes(mrand.Intn(64))
+	if err != nil {
+		return nil, err
+	}
+	return newECBOracle(prefix, secret, key), nil
+}
+
+// findOracleBlockSize returns the block size of the oracle by growing the
+// input until the size of the encrypted msg jumps.
+func findOracleBlockSize(oracle func([]byte) ([]byte, error)) (int, error) {
+	encryptedMsg, err := oracle(nil)
+	if err != nil {
+		return 0, err
+	}
+	initialLen := len(encryptedMsg)
+	maxBlockSize := 256
+	for i := 1; i <= maxBlockSize; i++ {
+		encryptedMsg, err = oracle(bytes.Repeat([]byte("A"), i))
+		if err != nil {
+			return 0, err
+		}
+		if len(encryptedMsg) > initialLen {
+			return len(encryptedMsg) - initialLen, nil
+		}
+	}
+	return 0, fmt.Errorf("Reach oracle max block size (%d)", maxBlockSize)
+}
+
+// findOraclePrefixLength returns the length of the prefix added by the oracle
+// in front of our msg. Two identical blocks show up as soon as our msg fills
+// the rest of the last prefix block plus 2 full blocks.
+func findOraclePrefixLength(oracle func([]byte) ([]byte, error), blockSize int) (int, error) {
+	for i := 0; i < blockSize; i++ {
+		encryptedA, err := oracle(bytes.Repeat([]byte("A"), 2*blockSize+i))
+		if err != nil {
+			return 0, err
+		}
+		encryptedB, err := oracle(bytes.Repeat([]byte("B"), 2*blockSize+i))
+		if err != nil {
+			return 0, err
+		}
+		for j := 0; j+2*blockSize <= len(encryptedA) && j+2*blockSize <= len(encryptedB); j += blockSize {
+			blockA, blockB := encryptedA[j:j+blockSize], encryptedB[j:j+blockSize]
+			// The prefix or the secret could hold identical blocks, or
+			// end or start with the filler byte. Only the blocks made of
+			// our filler are identical for both fillers and change with it.
+			if bytes.Equal(blockA, encryptedA[j+blockSize:j+2*blockSize]) &&
+				bytes.Equal(blockB, encryptedB[j+blockSize:j+2*blockSize]) &&
+				!bytes.Equal(blockA, blockB) {
+				return j - i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("Could not find 2 identical blocks")
+}
+
+// findOracleSecretLength returns the length of the secret appended by the
+// oracle after our msg.
+func findOracleSecretLength(oracle func([]byte) ([]byte, error), prefixLength int) (int, error) {
+	encryptedMsg, err := oracle(nil)
+	if err != nil {
+		return 0, err
+	}
+	initialLen := len(encryptedMsg)
+	for i := 1; i <= 256; i++ {
+		encryptedMsg, err = oracle(bytes.Repeat([]byte("A"), i))
+		if err != nil {
+			return 0, err
+		}
+		if len(encryptedMsg) > initialLen {
+			// prefix || msg || secret fills exactly initialLen for i bytes of msg
+			return initialLen - prefixLength - i, nil
+		}
+	}
+	return 0, fmt.Errorf("Could not find the secret length")
+}
+
+// buildOracleRainbow returns the map of the encrypted block at `offset` for all
+// the possible values of the last byte of the block following `msg`.
+func buildOracleRainbow(oracle func([]byte) ([]byte, error), msg []byte, offset, blockSize int) (map[string]byte, error) {
+	rainbow := make(map[string]byte)
+	constructedMsg := make([]byte, len(msg)+1)
+	copy(constructedMsg, msg)
+	for i := 0; i < 256; i++ {
+		constructedMsg[len(msg)] = byte(i)
+		encryptedMsg, err := oracle(constructedMsg)
+		if err != nil {
+			return nil, err
+		}
+		rainbow[string(encryptedMsg[offset:offset+blockSize])] = byte(i)
+	}
+	return rainbow, nil
+}
+
+// ByteAtATimeECBDecrypt recovers the secret appended by an ECB oracle
+// encrypting prefix || msg || secret, the prefix being of any fixed length.
+func ByteAtATimeECBDecrypt(oracle func([]byte) ([]byte, error)) ([]byte, error) {
+	blockSize, err := findOracleBlockSize(oracle)
+	if err != nil {
+		return nil, err
+	}
+	prefixLength, err := findOraclePrefixLength(oracle, blockSize)
+	if err != nil {
+		return nil, err
+	}
+	// align our msg on a block boundary
+	alignLength := (blockSize - prefixLength%blockSize) % blockSize
+	offset := prefixLength + alignLength
+	secretLength, err := findOracleSecretLength(oracle, prefixLength)
+	if err != nil {
+		return nil, err
+	}
+
+	filler := bytes.Repeat([]byte("A"), alignLength+blockSize)
+	guessed := make([]byte, 0, secretLength)
+	for i := 0; i < secretLength; i++ {
+		// push the byte to guess at the end of a block
+		shift := blockSize - 1 - i%blockSize
+		msg := filler[:alignLength+shift]
+		encryptedMsg, err := oracle(msg)
+		if err != nil {
+			return nil, err
+		}
+		blockOffset := offset + (i/blockSize)*blockSize
+
+		// the blockSize-1 bytes preceding the unknown byte
+		known := append(append([]byte{}, msg...), guessed...)
+		known = known[len(known)-(blockSize-1):]
+		rainbow, err := buildOracleRainbow(oracle, append(filler[:alignLength:alignLength], known...), offset, blockSize)
+		if err != nil {
+			return nil, err
+		}
+		b, ok := rainbow[string(encryptedMsg[blockOffset:blockOffset+blockSize])]
+		if !ok {
+			return guessed, fmt.Errorf("Could not guess byte %d", i)
+		}
+		guessed = append(guessed, b)
+	}
+	return guessed, nil
+}
+
+var (
+	userDataPrefix = []byte("comment1=cooking%20MCs;userdata=")
+	userDataSuffix = []byte(";comment2=%20like%20a%20pound%20of%20bacon")
+)
+
+// quoteUserData quotes out the ';' and '=' characters
+func quoteUserData(userData []byte) []byte {
+	quoted := bytes.Replace(userData, []byte(";"), []byte("%3B"), -1)
+	return bytes.Replace(quoted, []byte("="), []byte("%3D"), -1)
+}
+
+// buildUserDataMsg returns prefix || quoted userData || suffix
+func buildUserDataMsg(prefix, userData, suffix []byte) []byte {
+	quoted := quoteUserData(userData)
+	msg := make([]byte, 0, len(prefix)+len(quoted)+len(suffix))
+	msg = append(msg, prefix...)
+	msg = append(msg, quoted...)
+	return append(msg, suffix...)
+}
+
+// isAdminUserData returns true if the msg contains ";admin=true;"
+func isAdminUserData(msg []byte) bool {
+	return bytes.Contains(msg, []byte(";admin=true;"))
+}
+
+// CBCUserData encrypts quoted user data surrounded by a prefix and a suffix
+// with AES in CBC mode under a random key and iv.
+type CBCUserData struct {
+	prefix, suffix []byte
+	iv             []byte
+	c              cipher.Block
+}
+
+// NewCBCUserData creates a CBCUserData with the challenge 16 prefix and suffix
+func NewCBCUserData() (*CBCUserData, error) {
+	return newCBCUserData(userDataPrefix, userDataSuffix)
+}
+
+func newCBCUserData(prefix, suffix []byte) (*CBCUserData, error) {
+	rdm, err := GenerateRandomBytes(32)
+	if err != nil {
+		return nil, err
+	}
+	c, err := aes.NewCipher(rdm[:16])
+	if err != nil {
+		return nil, err
+	}
+	return &CBCUserData{prefix: prefix, suffix: suffix, iv: rdm[16:], c: c}, nil
+}
+
+// Encrypt returns prefix || quoted userData || suffix encrypted
+func (u *CBCUserData) Encrypt(userData []byte) ([]byte, error) {
+	msg := buildUserDataMsg(u.prefix, userData, u.suffix)
+	return CBCPadEncrypt(msg, u.iv, u.c)
+}
+
+// IsAdmin decrypts the msg and looks for ";admin=true;"
+func (u *CBCUserData) IsAdmin(encryptedMsg []byte) (bool, error) {
+	msg, err := CBCDecryptUnpad(encryptedMsg, u.iv, u.c)
+	if err != nil {
+		return false, err
+	}
+	return isAdminUserData(msg), nil
+}
+
+// findCBCPrefixLength returns the length of the prefix added in front of our
+// msg by a CBC oracle using a fixed iv.
+func findCBCPrefixLength(oracle func([]byte) ([]byte, error), blockSize int) (int, error) {
+	// the first block that differs contains the end of the prefix
+	encryptedA, err := oracle([]byte("A"))
+	if err != nil {
+		return 0, err
+	}
+	encryptedB, err := oracle([]byte("B"))
+	if err != nil {
+		return 0, err
+	}
+	block := -1
+	for i := 0; i+blockSize <= len(encryptedA) && i+blockSize <= len(encryptedB); i += blockSize {
+		if !bytes.Equal(encryptedA[i:i+blockSize], encryptedB[i:i+blockSize]) {
+			block = i / blockSize
+			break
+		}
+	}
+	if block == -1 {
+		return 0, fmt.Errorf("Oracle output does not depend on the msg")
+	}
+	// the block stops changing once our filler fills it
+	for i := 0; i <= blockSize; i++ {
+		filler := bytes.Repeat([]byte("A"), i)
+		encryptedA, err = oracle(append(append([]byte{}, filler...), 'A'))
+		if err != nil {
+			return 0, err
+		}
+		encryptedB, err = oracle(append(append([]byte{}, filler...), 'B'))
+		if err != nil {
+			return 0, err
+		}
+		start := block * blockSize
+		if bytes.Equal(encryptedA[start:start+blockSize], encryptedB[start:start+blockSize]) {
+			return (block+1)*blockSize - i, nil
+		}
+	}
+	return 0, fmt.Errorf("Could not find the end of the prefix")
+}
+
+// CBCBitflipInject returns an encrypted msg for which the decrypted msg
+// contains target. The target is injected by flipping bits of the block that
+// precedes it, which gets scrambled. The target can't be longer than a block.
+func CBCBitflipInject(oracle func([]byte) ([]byte, error), blockSize int, target []byte) ([]byte, error) {
+	if len(target) > blockSize {
+		return nil, fmt.Errorf("target must be at most %d bytes long", blockSize)
+	}
+	prefixLength, err := findCBCPrefixLength(oracle, blockSize)
+	if err != nil {
+		return nil, err
+	}
+	// align our msg on a block boundary, then a block to scramble, then a
+	// placeholder for the target
+	alignLength := (blockSize - prefixLength%blockSize) % blockSize
+	placeholder := bytes.Repeat([]byte("A"), len(target))
+	msg := bytes.Repeat([]byte("A"), alignLength+blockSize)
+	msg = append(msg, placeholder...)
+	encryptedMsg, err := oracle(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	scrambled := prefixLength + alignLength
+	for i := range target {
+		encryptedMsg[scrambled+i] ^= placeholder[i] ^ target[i]
+	}
+	return encryptedMsg, nil
+}
+
+// CBCForgeWithBlockEncryption returns an encrypted msg which decrypts to the
+// prefix of the oracle, a block of ours, then target. The suffix of the oracle
+// is dropped. Each block of the padded target is encrypted by using the oracle
+// as a block cipher: our msg XOR the encrypted block before it gives the
+// block cipher the input we need. unescaped reports whether the oracle leaves
+// a block of our msg unchanged.
+func CBCForgeWithBlockEncryption(oracle func([]byte) ([]byte, error), blockSize int, target []byte, unescaped func([]byte) bool) ([]byte, error) {
+	prefixLength, err := findCBCPrefixLength(oracle, blockSize)
+	if err != nil {
+		return nil, err
+	}
+	// align our msg on a block boundary
+	alignLength := (blockSize - prefixLength%blockSize) % blockSize
+	align := bytes.Repeat([]byte("A"), alignLength)
+	start := prefixLength + alignLength
+	encryptedMsg, err := oracle(append(append([]byte{}, align...), bytes.Repeat([]byte("A"), blockSize)...))
+	if err != nil {
+		return nil, err
+	}
+	forgedMsg := append([]byte{}, encryptedMsg[:start+blockSize]...)
+
+	paddedTarget, err := Pkcs7Pad(target, blockSize)
+	if err != nil {
+		return nil, err
+	}
+	input := make([]byte, blockSize)
+	for i := 0; i < len(paddedTarget); i += blockSize {
+		XORBytes(input, paddedTarget[i:i+blockSize], forgedMsg[len(forgedMsg)-blockSize:])
+		block, err := cbcOracleEncryptBlock(oracle, align, start, blockSize, input, unescaped)
+		if err != nil {
+			return nil, err
+		}
+		forgedMsg = append(forgedMsg, block...)
+	}
+	return forgedMsg, nil
+}
+
+// cbcOracleEncryptBlock returns the block cipher encryption of input. The
+// oracle encrypts a filler block at start followed by input XOR the encrypted
+// filler, trying other fillers until this block is unescaped.
+func cbcOracleEncryptBlock(oracle func([]byte) ([]byte, error), align []byte, start, blockSize int, input []byte, unescaped func([]byte) bool) ([]byte, error) {
+	msg := make([]byte, blockSize)
+	for c := byte('A'); c <= 'Z'; c++ {
+		filler := append(append([]byte{}, align...), bytes.Repeat([]byte{c}, blockSize)...)
+		encryptedFiller, err := oracle(filler)
+		if err != nil {
+			return nil, err
+		}
+		XORBytes(msg, input, encryptedFiller[start:start+blockSize])
+		if !unescaped(msg) {
+			continue
+		}
+		encryptedMsg, err := oracle(append(filler, msg...))
+		if err != nil {
+			return nil, err
+		}
+		return encryptedMsg[start+blockSize : start+2*blockSize], nil
+	}
+	return nil, fmt.Errorf("Could not find a block left unescaped by the oracle")
+}
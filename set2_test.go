@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/aes"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"testing"
 
@@ -64,6 +65,19 @@ func Test_Challenge10_ImplementCBCMode(t *testing.T) {
 	if bytes.Compare(encryptedMsg, msg) != 0 {
 		t.Fatalf("got = \n%q\nexpected = \n%q\n", encryptedMsg, msg)
 	}
+
+	// decrypt and unpad, then pad and encrypt
+	unpaddedMsg, err := CBCDecryptUnpad(msg, challenge.IV, cipher)
+	if err != nil {
+		t.Fatal("Could not unpad the decrypted msg", err)
+	}
+	encryptedMsg, err = CBCPadEncrypt(unpaddedMsg, challenge.IV, cipher)
+	if err != nil {
+		t.Fatal("Could not pad and encrypt the msg", err)
+	}
+	if bytes.Compare(encryptedMsg, msg) != 0 {
+		t.Fatalf("got = \n%q\nexpected = \n%q\n", encryptedMsg, msg)
+	}
 }
 
 func Test_Challenge11_EBC_CBC_DetectionOracle(t *testing.T) {
@@ -272,3 +286,222 @@ func Test_Challenge13_ECBCutAndPaste(t *testing.T) {
 		spew.Dump(adminProfile)
 	})
 }
+
+func Test_Challenge14_ByteAtATimeECBDecryptionHarder(t *testing.T) {
+	base64Msg := []byte("Um9sbGluJyBpbiBteSA1LjAKV2l0aCBteSByYWctdG9wIGRvd24gc28gbXkgaGFpciBjYW4gYmxvdwpUaGUgZ2lybGllcyBvbiBzdGFuZGJ5IHdhdmluZyBqdXN0IHRvIHNheSBoaQpEaWQgeW91IHN0b3A/IE5vLCBJIGp1c3QgZHJvdmUgYnkK")
+	secret, err := base64.StdEncoding.DecodeString(string(base64Msg))
+	if err != nil {
+		t.Fatal("Could not decode base64Msg", err)
+	}
+	key := []byte("YELLOW SUBMARINE")
+
+	t.Run("Find prefix length", func(t *testing.T) {
+		for _, prefix := range [][]byte{
+			[]byte(""),
+			[]byte("AAAAA"),
+			[]byte("0123456789abcdef"),
+			[]byte("0123456789abcdefghijklmnopqrstuvwxyzA"),
+			[]byte("0123456789abcdefghijkBBBBB"),
+			bytes.Repeat([]byte("P"), 32),
+			bytes.Repeat([]byte("P"), 37),
+		} {
+			oracle := newECBOracle(prefix, secret, key)
+			got, err := findOraclePrefixLength(oracle, len(key))
+			if err != nil {
+				t.Fatal("Could not find the prefix length", err)
+			}
+			if got != len(prefix) {
+				t.Fatalf("got = %d ; expected = %d", got, len(prefix))
+			}
+		}
+	})
+	t.Run("Repeated blocks in the prefix and the secret", func(t *testing.T) {
+		cases := []struct {
+			prefix, secret []byte
+		}{
+			{prefix: bytes.Repeat([]byte("P"), 32), secret: secret},
+			{prefix: bytes.Repeat([]byte("P"), 37), secret: secret},
+			{prefix: []byte("abc"), secret: append(bytes.Repeat([]byte("Z"), 64), secret...)},
+			{prefix: bytes.Repeat([]byte("A"), 40), secret: append(bytes.Repeat([]byte("A"), 40), secret...)},
+		}
+		for _, c := range cases {
+			guessedMsg, err := ByteAtATimeECBDecrypt(newECBOracle(c.prefix, c.secret, key))
+			if err != nil {
+				t.Fatalf("len(prefix) = %d: %v", len(c.prefix), err)
+			}
+			if !bytes.Equal(guessedMsg, c.secret) {
+				t.Fatalf("got = \n%q\n ; expected = \n%q\n", guessedMsg, c.secret)
+			}
+		}
+	})
+	t.Run("Without prefix", func(t *testing.T) {
+		guessedMsg, err := ByteAtATimeECBDecrypt(newECBOracle(nil, secret, key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(guessedMsg, secret) {
+			t.Fatalf("got = \n%q\n ; expected = \n%q\n", guessedMsg, secret)
+		}
+	})
+	t.Run("With random prefix", func(t *testing.T) {
+		oracle, err := newRandomPrefixECBOracle(secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		guessedMsg, err := ByteAtATimeECBDecrypt(oracle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Printf("guessedMsg = %s\n", guessedMsg)
+		if !bytes.Equal(guessedMsg, secret) {
+			t.Fatalf("got = \n%q\n ; expected = \n%q\n", guessedMsg, secret)
+		}
+	})
+}
+
+func Test_Challenge15_PKCS7PaddingValidation(t *testing.T) {
+	cases := []struct {
+		input, expected []byte
+		valid           bool
+	}{
+		{input: []byte("ICE ICE BABY\x04\x04\x04\x04"), expected: []byte("ICE ICE BABY"), valid: true},
+		{input: []byte("ICE ICE BABY\x05\x05\x05\x05"), valid: false},
+		{input: []byte("ICE ICE BABY\x01\x02\x03\x04"), valid: false},
+		{input: []byte("ICE ICE BABY123\x00"), valid: false},
+		{input: []byte("ICE ICE BABY123\x11"), valid: false},
+		{input: []byte("ICE ICE BABY\x04\x04\x04"), valid: false},
+		{input: []byte(""), valid: false},
+		{input: bytes.Repeat([]byte("\x10"), 16), expected: []byte(""), valid: true},
+	}
+	blockSize := 16
+
+	for _, c := range cases {
+		got, err := Pkcs7Unpad(c.input, blockSize)
+		if !c.valid {
+			if !errors.Is(err, ErrInvalidPadding) {
+				t.Fatalf("%q: expected ErrInvalidPadding ; got = %v", c.input, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: unexpected error %v", c.input, err)
+		}
+		if !bytes.Equal(got, c.expected) {
+			t.Fatalf("got = %q ; expected = %q", got, c.expected)
+		}
+	}
+
+	t.Run("Pad and unpad", func(t *testing.T) {
+		for i := 0; i < 3*blockSize; i++ {
+			msg := bytes.Repeat([]byte("A"), i)
+			paddedMsg, err := Pkcs7Pad(msg, blockSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Pkcs7Unpad(paddedMsg, blockSize)
+			if err != nil {
+				t.Fatalf("could not unpad %q: %v", paddedMsg, err)
+			}
+			if !bytes.Equal(got, msg) {
+				t.Fatalf("got = %q ; expected = %q", got, msg)
+			}
+		}
+	})
+}
+
+func Test_Challenge16_CBCBitflippingAttacks(t *testing.T) {
+	t.Run("Quote user data", func(t *testing.T) {
+		u, err := NewCBCUserData()
+		if err != nil {
+			t.Fatal(err)
+		}
+		encryptedMsg, err := u.Encrypt([]byte(";admin=true;"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ok, err := u.IsAdmin(encryptedMsg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			t.Fatal("user data is not quoted")
+		}
+	})
+	t.Run("Inject admin", func(t *testing.T) {
+		u, err := NewCBCUserData()
+		if err != nil {
+			t.Fatal(err)
+		}
+		encryptedMsg, err := CBCBitflipInject(u.Encrypt, aes.BlockSize, []byte(";admin=true;"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ok, err := u.IsAdmin(encryptedMsg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Fatal("Fail to inject ;admin=true;")
+		}
+		fmt.Println("Created an admin user data!!!!")
+	})
+	t.Run("Any prefix and target", func(t *testing.T) {
+		targets := [][]byte{
+			[]byte(";admin=true;"),
+			[]byte("x;admin=true;a=b"),
+			[]byte(";role=admin"),
+		}
+		for i := 0; i < 2*aes.BlockSize; i++ {
+			u, err := newCBCUserData(bytes.Repeat([]byte("P"), i), userDataSuffix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			target := targets[i%len(targets)]
+			encryptedMsg, err := CBCBitflipInject(u.Encrypt, aes.BlockSize, target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg, err := CBCDecryptUnpad(encryptedMsg, u.iv, u.c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Contains(msg, target) {
+				t.Fatalf("len(prefix) = %d: %q not found in %q", i, target, msg)
+			}
+			if !bytes.HasSuffix(msg, userDataSuffix) {
+				t.Fatalf("len(prefix) = %d: suffix not found in %q", i, msg)
+			}
+		}
+	})
+	t.Run("Target longer than a block", func(t *testing.T) {
+		targets := [][]byte{
+			[]byte(";admin=true;role=admin;comment=injected over several blocks;"),
+			bytes.Repeat([]byte("="), 2*aes.BlockSize),
+		}
+		unescaped := func(msg []byte) bool {
+			return bytes.Equal(quoteUserData(msg), msg)
+		}
+		for i := 0; i < 2*aes.BlockSize; i++ {
+			prefix := bytes.Repeat([]byte("P"), i)
+			u, err := newCBCUserData(prefix, userDataSuffix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			target := targets[i%len(targets)]
+			if _, err := CBCBitflipInject(u.Encrypt, aes.BlockSize, target); err == nil {
+				t.Fatal("CBCBitflipInject must reject targets longer than a block")
+			}
+			encryptedMsg, err := CBCForgeWithBlockEncryption(u.Encrypt, aes.BlockSize, target, unescaped)
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg, err := CBCDecryptUnpad(encryptedMsg, u.iv, u.c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(msg, prefix) || !bytes.HasSuffix(msg, target) {
+				t.Fatalf("len(prefix) = %d: unexpected msg %q", i, msg)
+			}
+		}
+	})
+}
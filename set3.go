@@ -0,0 +1,602 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	mrand "math/rand"
+	"sync"
+	"time"
+)
+
+var paddingOracleMsgs = []string{
+	"MDAwMDAwTm93IHRoYXQgdGhlIHBhcnR5IGlzIGp1bXBpbmc=",
+	"MDAwMDAxV2l0aCB0aGUgYmFzcyBraWNrZWQgaW4gYW5kIHRoZSBWZWdhJ3MgYXJlIHB1bXBpbic=",
+	"MDAwMDAyUXVpY2sgdG8gdGhlIHBvaW50LCB0byB0aGUgcG9pbnQsIG5vIGZha2luZw==",
+	"MDAwMDAzQ29va2luZyBNQydzIGxpa2UgYSBwb3VuZCBvZiBiYWNvbg==",
+	"MDAwMDA0QnVybmluZyAnZW0sIGlmIHlvdSBhaW4ndCBxdWljayBhbmQgbmltYmxl",
+	"MDAwMDA1SSBnbyBjcmF6eSB3aGVuIEkgaGVhciBhIGN5bWJhbCBhbmQgYQ==",
+	"MDAwMDA2QW5kIGEgaGlnaCBoYXQgd2l0aCBhIHNvdXBlZCB1cCB0ZW1wbw==",
+	"MDAwMDA3SSdtIG9uIGEgcm9sbCwgaXQncyB0aW1lIHRvIGdvIHNvbG8=",
+	"MDAwMDA4b2xsaW4nIGluIG15IGZpdmUgcG9pbnQgb2g=",
+	"MDAwMDA5aXRoIG15IHJhZy10b3AgZG93biBzbyBteSBoYWlyIGNhbiBibG93",
+}
+
+// CBCPaddingOracle encrypts one of the challenge 17 strings with AES in CBC
+// mode and tells whether an encrypted msg is properly padded.
+type CBCPaddingOracle struct {
+	c    cipher.Block
+	msgs [][]byte
+}
+
+// NewCBCPaddingOracle creates a CBCPaddingOracle with a random key
+func NewCBCPaddingOracle() (*CBCPaddingOracle, error) {
+	key, err := GenerateRandomBytes(16)
+	if err != nil {
+		return nil, err
+	}
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([][]byte, len(paddingOracleMsgs))
+	for i, m := range paddingOracleMsgs {
+		msgs[i], err = base64.StdEncoding.DecodeString(m)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &CBCPaddingOracle{c: c, msgs: msgs}, nil
+}
+
+// Encrypt returns one of the strings, picked at random, encrypted under a
+// random iv.
+func (o *CBCPaddingOracle) Encrypt() ([]byte, []byte, error) {
+	iv, err := GenerateRandomBytes(o.c.BlockSize())
+	if err != nil {
+		return nil, nil, err
+	}
+	encryptedMsg, err := CBCPadEncrypt(o.msgs[mrand.Intn(len(o.msgs))], iv, o.c)
+	if err != nil {
+		return nil, nil, err
+	}
+	return encryptedMsg, iv, nil
+}
+
+// IsPaddingValid decrypts the msg and returns true if its padding is valid
+func (o *CBCPaddingOracle) IsPaddingValid(encryptedMsg, iv []byte) bool {
+	_, err := CBCDecryptUnpad(encryptedMsg, iv, o.c)
+	return err == nil
+}
+
+// CBCPaddingOracleDecrypt recovers the msg encrypted in CBC mode with the help
+// of an oracle telling whether the padding of a decrypted msg is valid.
+func CBCPaddingOracleDecrypt(oracle func(encryptedMsg, iv []byte) bool, encryptedMsg, iv []byte, blockSize int) ([]byte, error) {
+	if len(encryptedMsg) == 0 || len(encryptedMsg)%blockSize != 0 {
+		return nil, fmt.Errorf("len(encryptedMsg) must be a multiple of %d", blockSize)
+	}
+	if len(iv) != blockSize {
+		return nil, fmt.Errorf("len(iv) must be %d", blockSize)
+	}
+	paddedMsg := make([]byte, 0, len(encryptedMsg))
+	prev := iv
+	for i := 0; i < len(encryptedMsg); i += blockSize {
+		block := encryptedMsg[i : i+blockSize]
+		intermediate, err := paddingOracleDecryptBlock(oracle, block, blockSize)
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %v", i/blockSize, err)
+		}
+		decryptedBlock := make([]byte, blockSize)
+		XORBytes(decryptedBlock, intermediate, prev)
+		paddedMsg = append(paddedMsg, decryptedBlock...)
+		prev = block
+	}
+	return Pkcs7Unpad(paddedMsg, blockSize)
+}
+
+// paddingOracleDecryptBlock returns the block decrypted by the block cipher,
+// before the XOR with the previous block, by forging ivs for which the
+// decrypted block ends with a valid padding.
+func paddingOracleDecryptBlock(oracle func(encryptedMsg, iv []byte) bool, block []byte, blockSize int) ([]byte, error) {
+	intermediate := make([]byte, blockSize)
+	forgedIV := make([]byte, blockSize)
+	for pos := blockSize - 1; pos >= 0; pos-- {
+		npad := byte(blockSize - pos)
+		// the bytes after pos decrypt to npad
+		for j := pos + 1; j < blockSize; j++ {
+			forgedIV[j] = intermediate[j] ^ npad
+		}
+		found := false
+		for guess := 0; guess < 256; guess++ {
+			forgedIV[pos] = byte(guess)
+			if !oracle(block, forgedIV) {
+				continue
+			}
+			if pos == blockSize-1 && pos > 0 {
+				// The decrypted block could end with \x02\x02 or
+				// \x03\x03\x03... instead of \x01. Changing the byte before
+				// breaks any padding but \x01.
+				forgedIV[pos-1] ^= 0xff
+				valid := oracle(block, forgedIV)
+				forgedIV[pos-1] ^= 0xff
+				if !valid {
+					continue
+				}
+			}
+			intermediate[pos] = byte(guess) ^ npad
+			found = true
+			break
+		}
+		if !found {
+			return nil, fmt.Errorf("no valid padding found for byte %d", pos)
+		}
+	}
+	return intermediate, nil
+}
+
+// CTRLayout describes how the nonce and the counter are laid out in the block
+// encrypted to produce the keystream.
+type CTRLayout int
+
+const (
+	// CTRLittleEndian64 is a 64 bit nonce followed by a 64 bit little endian
+	// counter, as used by cryptopals.
+	CTRLittleEndian64 CTRLayout = iota
+	// CTRBigEndian96 is a 96 bit nonce followed by a 32 bit big endian
+	// counter, as used by the standard library with a zero initial counter.
+	CTRBigEndian96
+)
+
+// CTR implements cipher.Stream using a block cipher in CTR mode
+type CTR struct {
+	c         cipher.Block
+	nonce     []byte
+	layout    CTRLayout
+	counter   uint64
+	keystream []byte
+	used      int
+}
+
+// NewCTR returns a CTR stream for the 128 bits block cipher c. The nonce is 8
+// bytes long for CTRLittleEndian64 and 12 bytes long for CTRBigEndian96.
+func NewCTR(c cipher.Block, nonce []byte, layout CTRLayout) (*CTR, error) {
+	if c.BlockSize() != 16 {
+		return nil, fmt.Errorf("CTR requires a 16 bytes block size, got %d", c.BlockSize())
+	}
+	switch layout {
+	case CTRLittleEndian64:
+		if len(nonce) != 8 {
+			return nil, fmt.Errorf("CTRLittleEndian64 requires an 8 bytes nonce, got %d", len(nonce))
+		}
+	case CTRBigEndian96:
+		if len(nonce) != 12 {
+			return nil, fmt.Errorf("CTRBigEndian96 requires a 12 bytes nonce, got %d", len(nonce))
+		}
+	default:
+		return nil, fmt.Errorf("Unknown CTR layout %d", layout)
+	}
+	s := &CTR{
+		c:         c,
+		nonce:     append([]byte{}, nonce...),
+		layout:    layout,
+		keystream: make([]byte, c.BlockSize()),
+	}
+	s.used = len(s.keystream)
+	return s, nil
+}
+
+// counterBlock returns the block encrypted for the given counter value
+func (s *CTR) counterBlock(counter uint64) []byte {
+	block := make([]byte, s.c.BlockSize())
+	copy(block, s.nonce)
+	switch s.layout {
+	case CTRLittleEndian64:
+		binary.LittleEndian.PutUint64(block[8:], counter)
+	case CTRBigEndian96:
+		binary.BigEndian.PutUint32(block[12:], uint32(counter))
+	}
+	return block
+}
+
+// XORKeyStream XORs each byte in src with a byte from the keystream
+func (s *CTR) XORKeyStream(dst, src []byte) {
+	if len(dst) < len(src) {
+		panic("cryptopals: output smaller than input")
+	}
+	for i := range src {
+		if s.used == len(s.keystream) {
+			s.c.Encrypt(s.keystream, s.counterBlock(s.counter))
+			s.counter++
+			s.used = 0
+		}
+		dst[i] = src[i] ^ s.keystream[s.used]
+		s.used++
+	}
+}
+
+// Seek moves the keystream to offset bytes from its beginning
+func (s *CTR) Seek(offset uint64) {
+	blockSize := uint64(s.c.BlockSize())
+	s.counter = offset / blockSize
+	s.used = len(s.keystream)
+	if offset%blockSize != 0 {
+		s.c.Encrypt(s.keystream, s.counterBlock(s.counter))
+		s.counter++
+		s.used = int(offset % blockSize)
+	}
+}
+
+// CTRCrypt encrypts or decrypts msg with the cryptopals CTR layout
+func CTRCrypt(msg []byte, c cipher.Block, nonce uint64) ([]byte, error) {
+	nonceBytes := make([]byte, 8)
+	binary.LittleEndian.PutUint64(nonceBytes, nonce)
+	s, err := NewCTR(c, nonceBytes, CTRLittleEndian64)
+	if err != nil {
+		return nil, err
+	}
+	dst := make([]byte, len(msg))
+	s.XORKeyStream(dst, msg)
+	return dst, nil
+}
+
+// FixedNonceCTR guesses the keystream shared by msgs encrypted in CTR mode
+// with the same key and nonce.
+type FixedNonceCTR struct {
+	EncryptedMsgs [][]byte
+	Keystream     []byte
+}
+
+// BreakFixedNonceCTR truncates the msgs to a common length and guesses the
+// keystream as a repeating key XOR, one byte at a time.
+func BreakFixedNonceCTR(encryptedMsgs [][]byte, scoringFn func(s string) float32) *FixedNonceCTR {
+	if len(encryptedMsgs) == 0 {
+		return &FixedNonceCTR{}
+	}
+	minLen := len(encryptedMsgs[0])
+	for _, m := range encryptedMsgs {
+		if len(m) < minLen {
+			minLen = len(m)
+		}
+	}
+	concatenatedMsg := make([]byte, 0, minLen*len(encryptedMsgs))
+	for _, m := range encryptedMsgs {
+		concatenatedMsg = append(concatenatedMsg, m[:minLen]...)
+	}
+	keystream := make([]byte, minLen)
+	if minLen > 0 {
+		blocks := getTranposedBlocks(concatenatedMsg, minLen)
+		for i := range blocks {
+			_, byteCipher, _ := breakSingleByteXOR(blocks[i], scoringFn)
+			keystream[i] = byteCipher
+		}
+	}
+	return &FixedNonceCTR{EncryptedMsgs: encryptedMsgs, Keystream: keystream}
+}
+
+// Guess updates the keystream so that the msg i decrypts to guess at offset.
+// The keystream grows when the guess goes past its end.
+func (f *FixedNonceCTR) Guess(i, offset int, guess []byte) error {
+	if i < 0 || i >= len(f.EncryptedMsgs) {
+		return fmt.Errorf("No msg %d", i)
+	}
+	encryptedMsg := f.EncryptedMsgs[i]
+	if offset < 0 || offset+len(guess) > len(encryptedMsg) {
+		return fmt.Errorf("guess goes past the end of msg %d", i)
+	}
+	if offset > len(f.Keystream) {
+		return fmt.Errorf("guess leaves a gap in the keystream (len = %d)", len(f.Keystream))
+	}
+	if n := offset + len(guess); n > len(f.Keystream) {
+		f.Keystream = append(f.Keystream, make([]byte, n-len(f.Keystream))...)
+	}
+	XORBytes(f.Keystream[offset:], encryptedMsg[offset:], guess)
+	return nil
+}
+
+// Decrypt returns the msg i decrypted as far as the keystream is known
+func (f *FixedNonceCTR) Decrypt(i int) ([]byte, error) {
+	if i < 0 || i >= len(f.EncryptedMsgs) {
+		return nil, fmt.Errorf("No msg %d", i)
+	}
+	msg := make([]byte, len(f.EncryptedMsgs[i]))
+	n := XORBytes(msg, f.EncryptedMsgs[i], f.Keystream)
+	return msg[:n], nil
+}
+
+// DecryptAll returns all the msgs decrypted as far as the keystream is known
+func (f *FixedNonceCTR) DecryptAll() [][]byte {
+	msgs := make([][]byte, len(f.EncryptedMsgs))
+	for i := range f.EncryptedMsgs {
+		msgs[i], _ = f.Decrypt(i)
+	}
+	return msgs
+}
+
+// MT19937 parameters
+const (
+	mtN         = 624
+	mtM         = 397
+	mtF         = 1812433253
+	mtMatrixA   = 0x9908b0df
+	mtUpperMask = 0x80000000
+	mtLowerMask = 0x7fffffff
+)
+
+// MT19937 is the 32 bits Mersenne Twister pseudo random number generator
+type MT19937 struct {
+	state [mtN]uint32
+	index int
+}
+
+// NewMT19937 returns a MT19937 initialized with seed
+func NewMT19937(seed uint32) *MT19937 {
+	mt := &MT19937{}
+	mt.Seed(seed)
+	return mt
+}
+
+// Seed initializes the state of the generator
+func (mt *MT19937) Seed(seed uint32) {
+	mt.state[0] = seed
+	for i := 1; i < mtN; i++ {
+		mt.state[i] = mtF*(mt.state[i-1]^(mt.state[i-1]>>30)) + uint32(i)
+	}
+	mt.index = mtN
+}
+
+// twist generates the next mtN words of the state
+func (mt *MT19937) twist() {
+	for i := 0; i < mtN; i++ {
+		y := (mt.state[i] & mtUpperMask) | (mt.state[(i+1)%mtN] & mtLowerMask)
+		next := mt.state[(i+mtM)%mtN] ^ (y >> 1)
+		if y&1 != 0 {
+			next ^= mtMatrixA
+		}
+		mt.state[i] = next
+	}
+	mt.index = 0
+}
+
+// temper applies the MT19937 tempering transform to a word of the state
+func temper(y uint32) uint32 {
+	y ^= y >> 11
+	y ^= (y << 7) & 0x9d2c5680
+	y ^= (y << 15) & 0xefc60000
+	y ^= y >> 18
+	return y
+}
+
+// Uint32 returns the next pseudo random number
+func (mt *MT19937) Uint32() uint32 {
+	if mt.index >= mtN {
+		mt.twist()
+	}
+	y := mt.state[mt.index]
+	mt.index++
+	return temper(y)
+}
+
+// MT19937Source adapts a MT19937 to math/rand.Source64
+type MT19937Source struct {
+	mt *MT19937
+}
+
+// NewMT19937Source returns a math/rand.Source64 backed by a MT19937. Only the
+// lower 32 bits of the seed are used.
+func NewMT19937Source(seed int64) *MT19937Source {
+	return &MT19937Source{mt: NewMT19937(uint32(seed))}
+}
+
+// Seed initializes the generator with the lower 32 bits of seed
+func (s *MT19937Source) Seed(seed int64) {
+	s.mt.Seed(uint32(seed))
+}
+
+// Uint64 returns a pseudo random uint64 built from 2 outputs
+func (s *MT19937Source) Uint64() uint64 {
+	return uint64(s.mt.Uint32())<<32 | uint64(s.mt.Uint32())
+}
+
+// Int63 returns a non negative pseudo random int64
+func (s *MT19937Source) Int63() int64 {
+	return int64(s.Uint64() >> 1)
+}
+
+// Clock tells the time and waits
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+type systemClock struct{}
+
+// defaultClock is the Clock used by the oracles seeding generators with the time
+var defaultClock Clock = systemClock{}
+
+func (systemClock) Now() time.Time        { return time.Now() }
+func (systemClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// SimulatedClock is a Clock whose time only moves forward when sleeping. It
+// is safe for concurrent use.
+type SimulatedClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewSimulatedClock returns a SimulatedClock starting at now
+func NewSimulatedClock(now time.Time) *SimulatedClock {
+	return &SimulatedClock{now: now}
+}
+
+// Now returns the simulated time
+func (c *SimulatedClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Sleep moves the simulated time forward by d without waiting
+func (c *SimulatedClock) Sleep(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// timestampSeededOutput waits a random number of seconds between 40 and 1000,
+// seeds a MT19937 with the current unix timestamp, waits again and returns
+// the first output of the generator.
+func timestampSeededOutput(clock Clock) uint32 {
+	clock.Sleep(time.Duration(40+mrand.Intn(961)) * time.Second)
+	mt := NewMT19937(uint32(clock.Now().Unix()))
+	clock.Sleep(time.Duration(40+mrand.Intn(961)) * time.Second)
+	return mt.Uint32()
+}
+
+// CrackMT19937TimeSeed returns the unix timestamp between from and to used to
+// seed the MT19937 that produced output as its first value.
+func CrackMT19937TimeSeed(output uint32, from, to time.Time) (uint32, error) {
+	mt := &MT19937{}
+	for seed := from.Unix(); seed <= to.Unix(); seed++ {
+		mt.Seed(uint32(seed))
+		if mt.Uint32() == output {
+			return uint32(seed), nil
+		}
+	}
+	return 0, fmt.Errorf("No seed between %v and %v gives %d", from, to, output)
+}
+
+// undoRightShiftXOR inverts y = x ^ (x >> shift)
+func undoRightShiftXOR(y uint32, shift uint) uint32 {
+	x := y
+	// each iteration recovers shift more bits from the top
+	for i := uint(0); i < 32/shift; i++ {
+		x = y ^ (x >> shift)
+	}
+	return x
+}
+
+// undoLeftShiftXOR inverts y = x ^ ((x << shift) & mask)
+func undoLeftShiftXOR(y uint32, shift uint, mask uint32) uint32 {
+	x := y
+	// each iteration recovers shift more bits from the bottom
+	for i := uint(0); i < 32/shift; i++ {
+		x = y ^ ((x << shift) & mask)
+	}
+	return x
+}
+
+// Untemper inverts the MT19937 tempering transform and returns the word of
+// the state that produced the output y.
+func Untemper(y uint32) uint32 {
+	y = undoRightShiftXOR(y, 18)
+	y = undoLeftShiftXOR(y, 15, 0xefc60000)
+	y = undoLeftShiftXOR(y, 7, 0x9d2c5680)
+	y = undoRightShiftXOR(y, 11)
+	return y
+}
+
+// CloneMT19937 returns a generator predicting the outputs following the 624
+// consecutive outputs of a MT19937.
+func CloneMT19937(outputs []uint32) (*MT19937, error) {
+	if len(outputs) != mtN {
+		return nil, fmt.Errorf("Cloning requires %d outputs, got %d", mtN, len(outputs))
+	}
+	mt := &MT19937{index: mtN}
+	for i, y := range outputs {
+		mt.state[i] = Untemper(y)
+	}
+	return mt, nil
+}
+
+// MT19937Stream implements cipher.Stream with a keystream made of the bytes
+// of the outputs of a MT19937.
+type MT19937Stream struct {
+	mt        *MT19937
+	keystream [4]byte
+	used      int
+}
+
+// NewMT19937Stream returns a MT19937Stream keyed with a 16 bits seed
+func NewMT19937Stream(seed uint16) *MT19937Stream {
+	return newMT19937Stream(NewMT19937(uint32(seed)))
+}
+
+func newMT19937Stream(mt *MT19937) *MT19937Stream {
+	return &MT19937Stream{mt: mt, used: 4}
+}
+
+// XORKeyStream XORs each byte in src with a byte from the keystream
+func (s *MT19937Stream) XORKeyStream(dst, src []byte) {
+	if len(dst) < len(src) {
+		panic("cryptopals: output smaller than input")
+	}
+	for i := range src {
+		if s.used == len(s.keystream) {
+			binary.LittleEndian.PutUint32(s.keystream[:], s.mt.Uint32())
+			s.used = 0
+		}
+		dst[i] = src[i] ^ s.keystream[s.used]
+		s.used++
+	}
+}
+
+// MT19937Crypt encrypts or decrypts msg with the MT19937 stream cipher
+func MT19937Crypt(msg []byte, seed uint16) []byte {
+	dst := make([]byte, len(msg))
+	NewMT19937Stream(seed).XORKeyStream(dst, msg)
+	return dst
+}
+
+// mt19937PrefixOracle encrypts msg behind 5 to 20 random bytes under a random
+// 16 bits seed, which is returned to check the attack.
+func mt19937PrefixOracle(msg []byte) ([]byte, uint16, error) {
+	prefix, err := GenerateRandomBytes(5 + mrand.Intn(16))
+	if err != nil {
+		return nil, 0, err
+	}
+	seed := uint16(mrand.Intn(1 << 16))
+	return MT19937Crypt(append(prefix, msg...), seed), seed, nil
+}
+
+// CrackMT19937StreamSeed returns the 16 bits seed of the MT19937 stream
+// cipher used to encrypt a msg ending with knownSuffix.
+func CrackMT19937StreamSeed(encryptedMsg, knownSuffix []byte) (uint16, error) {
+	if len(knownSuffix) > len(encryptedMsg) {
+		return 0, fmt.Errorf("known suffix is longer than the encrypted msg")
+	}
+	offset := len(encryptedMsg) - len(knownSuffix)
+	for seed := 0; seed < 1<<16; seed++ {
+		msg := MT19937Crypt(encryptedMsg, uint16(seed))
+		if bytes.Equal(msg[offset:], knownSuffix) {
+			return uint16(seed), nil
+		}
+	}
+	return 0, fmt.Errorf("No seed decrypts the known suffix")
+}
+
+// generateResetToken returns a password reset token made of the keystream of
+// a MT19937 seeded with the current unix timestamp.
+func generateResetToken(clock Clock) []byte {
+	token := make([]byte, 16)
+	newMT19937Stream(NewMT19937(uint32(clock.Now().Unix()))).XORKeyStream(token, token)
+	return token
+}
+
+// IsTimeSeededMT19937Token returns true if token is the keystream of a MT19937
+// seeded with a unix timestamp between from and to.
+func IsTimeSeededMT19937Token(token []byte, from, to time.Time) bool {
+	keystream := make([]byte, len(token))
+	for seed := from.Unix(); seed <= to.Unix(); seed++ {
+		for i := range keystream {
+			keystream[i] = 0
+		}
+		newMT19937Stream(NewMT19937(uint32(seed))).XORKeyStream(keystream, keystream)
+		if bytes.Equal(keystream, token) {
+			return true
+		}
+	}
+	return false
+}
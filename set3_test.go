@@ -0,0 +1,498 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	mrand "math/rand"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Challenge17_CBCPaddingOracle(t *testing.T) {
+	oracle, err := NewCBCPaddingOracle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("Valid padding", func(t *testing.T) {
+		encryptedMsg, iv, err := oracle.Encrypt()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !oracle.IsPaddingValid(encryptedMsg, iv) {
+			t.Fatal("Encrypted msg must have a valid padding")
+		}
+		encryptedMsg[len(encryptedMsg)-aes.BlockSize-1] ^= 0xff
+		if oracle.IsPaddingValid(encryptedMsg, iv) {
+			t.Fatal("Tampered msg must have an invalid padding")
+		}
+	})
+	t.Run("Decrypt", func(t *testing.T) {
+		for i := 0; i < 20; i++ {
+			encryptedMsg, iv, err := oracle.Encrypt()
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg, err := CBCPaddingOracleDecrypt(oracle.IsPaddingValid, encryptedMsg, iv, aes.BlockSize)
+			if err != nil {
+				t.Fatal("Could not decrypt the msg", err)
+			}
+			fmt.Printf("msg = %q\n", msg)
+			found := false
+			for _, m := range oracle.msgs {
+				if bytes.Equal(m, msg) {
+					found = true
+				}
+			}
+			if !found {
+				t.Fatalf("%q is not one of the encrypted strings", msg)
+			}
+		}
+	})
+	t.Run("Last byte false positive", func(t *testing.T) {
+		// msg ending with \x02 before the padding. The iv makes the block
+		// decrypt to ...\x02\x03 under a zero iv so that guess 1, giving
+		// \x02\x02, comes before guess 2 giving the expected \x01.
+		msg := []byte("0123456789abcd\x02")
+		iv := make([]byte, aes.BlockSize)
+		iv[aes.BlockSize-1] = 0x02
+		encryptedMsg, err := CBCPadEncrypt(msg, iv, oracle.c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := CBCPaddingOracleDecrypt(oracle.IsPaddingValid, encryptedMsg, iv, aes.BlockSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, msg) {
+			t.Fatalf("got = %q ; expected = %q", got, msg)
+		}
+	})
+}
+
+func Test_Challenge18_ImplementCTRMode(t *testing.T) {
+	challenge := struct {
+		input string
+		key   []byte
+		nonce uint64
+	}{
+		input: "L77na/nrFsKvynd6HzOoG7GHTLXsTVu9qvY/2syLXzhPweyyMTJULu/6/kXX0KSvoOLSFQ==",
+		key:   []byte("YELLOW SUBMARINE"),
+		nonce: 0,
+	}
+	encryptedMsg, err := base64.StdEncoding.DecodeString(challenge.input)
+	if err != nil {
+		t.Fatal("Could not decode the input", err)
+	}
+	c, err := aes.NewCipher(challenge.key)
+	if err != nil {
+		t.Fatal("Could not create the aes cipher", err)
+	}
+
+	t.Run("Decrypt", func(t *testing.T) {
+		msg, err := CTRCrypt(encryptedMsg, c, challenge.nonce)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Printf("msg = %q\n", msg)
+		expected := []byte("Yo, VIP Let's kick it Ice, Ice, baby Ice, Ice, baby ")
+		if !bytes.Equal(msg, expected) {
+			t.Fatalf("got = %q ; expected = %q", msg, expected)
+		}
+		encryptedAgain, err := CTRCrypt(msg, c, challenge.nonce)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(encryptedAgain, encryptedMsg) {
+			t.Fatalf("got = %q ; expected = %q", encryptedAgain, encryptedMsg)
+		}
+	})
+	t.Run("Byte by byte", func(t *testing.T) {
+		s, err := NewCTR(c, make([]byte, 8), CTRLittleEndian64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := make([]byte, len(encryptedMsg))
+		for i := range encryptedMsg {
+			s.XORKeyStream(msg[i:i+1], encryptedMsg[i:i+1])
+		}
+		expected, err := CTRCrypt(encryptedMsg, c, challenge.nonce)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(msg, expected) {
+			t.Fatalf("got = %q ; expected = %q", msg, expected)
+		}
+	})
+	t.Run("Standard library layout", func(t *testing.T) {
+		nonce := []byte("0123456789ab")
+		s, err := NewCTR(c, nonce, CTRBigEndian96)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := bytes.Repeat([]byte("YELLOW SUBMARINE"), 5)
+		got := make([]byte, len(msg))
+		s.XORKeyStream(got, msg)
+
+		expected := make([]byte, len(msg))
+		cipher.NewCTR(c, append(nonce, 0, 0, 0, 0)).XORKeyStream(expected, msg)
+		if !bytes.Equal(got, expected) {
+			t.Fatalf("got = %x ; expected = %x", got, expected)
+		}
+	})
+	t.Run("Seek", func(t *testing.T) {
+		expected, err := CTRCrypt(encryptedMsg, c, challenge.nonce)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, offset := range []int{0, 1, 15, 16, 17, 32, len(encryptedMsg) - 1} {
+			s, err := NewCTR(c, make([]byte, 8), CTRLittleEndian64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.Seek(uint64(offset))
+			msg := make([]byte, len(encryptedMsg)-offset)
+			s.XORKeyStream(msg, encryptedMsg[offset:])
+			if !bytes.Equal(msg, expected[offset:]) {
+				t.Fatalf("offset = %d ; got = %q ; expected = %q", offset, msg, expected[offset:])
+			}
+		}
+	})
+	t.Run("Stream reader", func(t *testing.T) {
+		s, err := NewCTR(c, make([]byte, 8), CTRLittleEndian64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := ioutil.ReadAll(cipher.StreamReader{S: s, R: bytes.NewReader(encryptedMsg)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Printf("msg = %q\n", msg)
+	})
+	t.Run("Invalid nonce", func(t *testing.T) {
+		if _, err := NewCTR(c, make([]byte, 12), CTRLittleEndian64); err == nil {
+			t.Fatal("12 bytes nonce must be rejected for CTRLittleEndian64")
+		}
+		if _, err := NewCTR(c, make([]byte, 8), CTRBigEndian96); err == nil {
+			t.Fatal("8 bytes nonce must be rejected for CTRBigEndian96")
+		}
+	})
+}
+
+// encryptFileLinesWithFixedNonce returns each base64 encoded line of the file
+// and its encryption in CTR mode under the same random key and a 0 nonce.
+func encryptFileLinesWithFixedNonce(fname string) ([][]byte, [][]byte, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := GenerateRandomBytes(16)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	var msgs, encryptedMsgs [][]byte
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		msg, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			return nil, nil, err
+		}
+		encryptedMsg, err := CTRCrypt(msg, c, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		msgs = append(msgs, msg)
+		encryptedMsgs = append(encryptedMsgs, encryptedMsg)
+	}
+	return msgs, encryptedMsgs, nil
+}
+
+// countMatchingBytes returns the number of bytes equal in a and b ignoring case
+func countMatchingBytes(a, b [][]byte) (int, int) {
+	matching, total := 0, 0
+	for i := range a {
+		for j := 0; j < len(a[i]) && j < len(b[i]); j++ {
+			if bytes.EqualFold(a[i][j:j+1], b[i][j:j+1]) {
+				matching++
+			}
+			total++
+		}
+	}
+	return matching, total
+}
+
+func Test_Challenge19_BreakFixedNonceCTRUsingSubstitutions(t *testing.T) {
+	msgs, encryptedMsgs, err := encryptFileLinesWithFixedNonce("data/challenge-data-19.txt")
+	if err != nil {
+		t.Fatal("Could not read and encrypt the challenge data", err)
+	}
+	f := BreakFixedNonceCTR(encryptedMsgs, scoreEnglishText)
+	for _, m := range f.DecryptAll() {
+		fmt.Printf("%q\n", m)
+	}
+	matching, total := countMatchingBytes(f.DecryptAll(), msgs)
+	fmt.Printf("guessed %d/%d bytes\n", matching, total)
+	if matching < total*9/10 {
+		t.Fatalf("Only guessed %d/%d bytes", matching, total)
+	}
+
+	t.Run("Refine the keystream", func(t *testing.T) {
+		// Guessing the rest of a line gives away the same bytes of the others
+		longest := 0
+		for i := range encryptedMsgs {
+			if len(encryptedMsgs[i]) > len(encryptedMsgs[longest]) {
+				longest = i
+			}
+		}
+		if err := f.Guess(longest, 0, msgs[longest]); err != nil {
+			t.Fatal(err)
+		}
+		for i, m := range f.DecryptAll() {
+			if !bytes.Equal(m, msgs[i]) {
+				t.Fatalf("got = %q ; expected = %q", m, msgs[i])
+			}
+		}
+	})
+	t.Run("Invalid guess", func(t *testing.T) {
+		if err := f.Guess(0, len(encryptedMsgs[0]), []byte("too long")); err == nil {
+			t.Fatal("guess past the end of the msg must be rejected")
+		}
+		if _, err := f.Decrypt(len(encryptedMsgs)); err == nil {
+			t.Fatal("Decrypt must reject an unknown msg")
+		}
+	})
+}
+
+func Test_Challenge20_BreakFixedNonceCTRStatistically(t *testing.T) {
+	fname := "data/challenge-data-20.txt"
+	if _, err := os.Stat(fname); os.IsNotExist(err) {
+		t.Skip("Missing challenge data", fname)
+	}
+	msgs, encryptedMsgs, err := encryptFileLinesWithFixedNonce(fname)
+	if err != nil {
+		t.Fatal("Could not read and encrypt the challenge data", err)
+	}
+	f := BreakFixedNonceCTR(encryptedMsgs, scoreEnglishText)
+	for _, m := range f.DecryptAll() {
+		fmt.Printf("%s\n", m)
+	}
+	matching, total := countMatchingBytes(f.DecryptAll(), msgs)
+	fmt.Printf("guessed %d/%d bytes\n", matching, total)
+	if matching < total*9/10 {
+		t.Fatalf("Only guessed %d/%d bytes", matching, total)
+	}
+}
+
+func Test_Challenge21_ImplementMT19937(t *testing.T) {
+	t.Run("Reference outputs", func(t *testing.T) {
+		cases := []struct {
+			seed     uint32
+			expected []uint32
+		}{
+			{seed: 5489, expected: []uint32{3499211612, 581869302, 3890346734, 3586334585, 545404204}},
+			{seed: 1, expected: []uint32{1791095845, 4282876139, 3093770124, 4005303368, 491263}},
+		}
+		for _, c := range cases {
+			mt := NewMT19937(c.seed)
+			for i, expected := range c.expected {
+				if got := mt.Uint32(); got != expected {
+					t.Fatalf("seed = %d ; output %d got = %d ; expected = %d", c.seed, i, got, expected)
+				}
+			}
+		}
+	})
+	t.Run("10000th output", func(t *testing.T) {
+		mt := NewMT19937(5489)
+		var got uint32
+		for i := 0; i < 10000; i++ {
+			got = mt.Uint32()
+		}
+		if expected := uint32(4123659995); got != expected {
+			t.Fatalf("got = %d ; expected = %d", got, expected)
+		}
+	})
+	t.Run("Reseed", func(t *testing.T) {
+		mt := NewMT19937(42)
+		first := mt.Uint32()
+		mt.Uint32()
+		mt.Seed(42)
+		if got := mt.Uint32(); got != first {
+			t.Fatalf("got = %d ; expected = %d", got, first)
+		}
+	})
+	t.Run("math/rand source", func(t *testing.T) {
+		var _ mrand.Source64 = NewMT19937Source(0)
+		r1 := mrand.New(NewMT19937Source(5489))
+		r2 := mrand.New(NewMT19937Source(5489))
+		for i := 0; i < 100; i++ {
+			if a, b := r1.Intn(1000), r2.Intn(1000); a != b {
+				t.Fatalf("same seed gave different outputs %d != %d", a, b)
+			}
+		}
+		mt := NewMT19937(5489)
+		expected := uint64(mt.Uint32())<<32 | uint64(mt.Uint32())
+		if got := NewMT19937Source(5489).Uint64(); got != expected {
+			t.Fatalf("got = %d ; expected = %d", got, expected)
+		}
+	})
+}
+
+func Test_Challenge22_CrackMT19937Seed(t *testing.T) {
+	clock := NewSimulatedClock(time.Now())
+	start := clock.Now()
+	output := timestampSeededOutput(clock)
+	end := clock.Now()
+	fmt.Printf("waited %v ; output = %d\n", end.Sub(start), output)
+
+	seed, err := CrackMT19937TimeSeed(output, start, end)
+	if err != nil {
+		t.Fatal("Could not crack the seed", err)
+	}
+	fmt.Println("seed =", seed)
+	if got := NewMT19937(seed).Uint32(); got != output {
+		t.Fatalf("got = %d ; expected = %d", got, output)
+	}
+	if seed < uint32(start.Unix()+40) || seed > uint32(end.Unix()-40) {
+		t.Fatalf("seed %d is outside of the expected window", seed)
+	}
+
+	t.Run("encryptionOracle seed", func(t *testing.T) {
+		// encryptionOracle seeds the MT19937 picking ECB or CBC with the time
+		clock := NewSimulatedClock(time.Now())
+		defer func(c Clock) { defaultClock = c }(defaultClock)
+		defaultClock = clock
+		start := clock.Now()
+		clock.Sleep(time.Duration(40+mrand.Intn(961)) * time.Second)
+		expected := clock.Now().Unix()
+		var modes []bool
+		for i := 0; i < 32; i++ {
+			encryptedMsg, err := encryptionOracle(make([]byte, 48))
+			if err != nil {
+				t.Fatal(err)
+			}
+			modes = append(modes, bytes.Equal(encryptedMsg[16:32], encryptedMsg[32:48]))
+			clock.Sleep(time.Second)
+		}
+
+		var seeds []int64
+		for seed := start.Unix(); seed <= clock.Now().Unix(); seed++ {
+			if matchEncryptionOracleModes(seed, modes) {
+				seeds = append(seeds, seed)
+			}
+		}
+		fmt.Println("encryptionOracle seeds =", seeds)
+		if len(seeds) != 1 || seeds[0] != expected {
+			t.Fatalf("got = %v ; expected = [%d]", seeds, expected)
+		}
+	})
+}
+
+// matchEncryptionOracleModes returns true if encryptionOracle called once per
+// second from seed picks ECB for the true values of modes.
+func matchEncryptionOracleModes(seed int64, modes []bool) bool {
+	for i, ecb := range modes {
+		rng := mrand.New(NewMT19937Source(seed + int64(i)))
+		// prefix and suffix lengths
+		rng.Intn(5)
+		rng.Intn(5)
+		if (rng.Intn(2) == 1) != ecb {
+			return false
+		}
+	}
+	return true
+}
+
+func Test_Challenge23_CloneMT19937(t *testing.T) {
+	t.Run("Untemper", func(t *testing.T) {
+		for _, y := range []uint32{0, 1, 0xffffffff, 0x80000000, 0xdeadbeef, 123456789} {
+			if got := Untemper(temper(y)); got != y {
+				t.Fatalf("got = %#x ; expected = %#x", got, y)
+			}
+		}
+		for i := 0; i < 10000; i++ {
+			y := mrand.Uint32()
+			if got := Untemper(temper(y)); got != y {
+				t.Fatalf("got = %#x ; expected = %#x", got, y)
+			}
+		}
+	})
+	t.Run("Clone", func(t *testing.T) {
+		mt := NewMT19937(mrand.Uint32())
+		// start in the middle of the state to not rely on the alignment
+		skip := mrand.Intn(mtN)
+		for i := 0; i < skip; i++ {
+			mt.Uint32()
+		}
+		outputs := make([]uint32, mtN)
+		for i := range outputs {
+			outputs[i] = mt.Uint32()
+		}
+		clone, err := CloneMT19937(outputs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 5000; i++ {
+			if got, expected := clone.Uint32(), mt.Uint32(); got != expected {
+				t.Fatalf("output %d got = %d ; expected = %d", i, got, expected)
+			}
+		}
+	})
+	t.Run("Not enough outputs", func(t *testing.T) {
+		if _, err := CloneMT19937(make([]uint32, 10)); err == nil {
+			t.Fatal("10 outputs must be rejected")
+		}
+	})
+}
+
+func Test_Challenge24_MT19937StreamCipher(t *testing.T) {
+	t.Run("Encrypt and decrypt", func(t *testing.T) {
+		msg := []byte("YELLOW SUBMARINE YELLOW SUBMARINE")
+		encryptedMsg := MT19937Crypt(msg, 1234)
+		if bytes.Equal(encryptedMsg, msg) {
+			t.Fatal("msg is not encrypted")
+		}
+		if got := MT19937Crypt(encryptedMsg, 1234); !bytes.Equal(got, msg) {
+			t.Fatalf("got = %q ; expected = %q", got, msg)
+		}
+		var _ cipher.Stream = NewMT19937Stream(0)
+	})
+	t.Run("Recover the seed", func(t *testing.T) {
+		knownSuffix := bytes.Repeat([]byte("A"), 14)
+		encryptedMsg, seed, err := mt19937PrefixOracle(knownSuffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := CrackMT19937StreamSeed(encryptedMsg, knownSuffix)
+		if err != nil {
+			t.Fatal("Could not recover the seed", err)
+		}
+		fmt.Println("seed =", got)
+		if got != seed {
+			t.Fatalf("got = %d ; expected = %d", got, seed)
+		}
+	})
+	t.Run("Detect time seeded token", func(t *testing.T) {
+		clock := NewSimulatedClock(time.Now())
+		clock.Sleep(time.Duration(mrand.Intn(3600)) * time.Second)
+		token := generateResetToken(clock)
+		now := clock.Now()
+		if !IsTimeSeededMT19937Token(token, now.Add(-time.Hour), now) {
+			t.Fatal("Token generated from the time is not detected")
+		}
+		randomToken, err := GenerateRandomBytes(len(token))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if IsTimeSeededMT19937Token(randomToken, now.Add(-time.Hour), now) {
+			t.Fatal("Random token is detected as time seeded")
+		}
+	})
+}
@@ -0,0 +1,680 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"math/bits"
+	mrand "math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// CTREdit returns the msg encrypted in CTR mode with the bytes from offset
+// replaced by newText. Only the keystream under newText is generated.
+func CTREdit(encryptedMsg []byte, c cipher.Block, nonce uint64, offset int, newText []byte) ([]byte, error) {
+	if offset < 0 || offset > len(encryptedMsg) {
+		return nil, fmt.Errorf("offset %d is out of the encrypted msg (len = %d)", offset, len(encryptedMsg))
+	}
+	nonceBytes := make([]byte, 8)
+	binary.LittleEndian.PutUint64(nonceBytes, nonce)
+	s, err := NewCTR(c, nonceBytes, CTRLittleEndian64)
+	if err != nil {
+		return nil, err
+	}
+	s.Seek(uint64(offset))
+
+	editedMsg := make([]byte, offset, offset+len(newText))
+	copy(editedMsg, encryptedMsg)
+	editedMsg = append(editedMsg, make([]byte, len(newText))...)
+	s.XORKeyStream(editedMsg[offset:], newText)
+	if end := offset + len(newText); end < len(encryptedMsg) {
+		editedMsg = append(editedMsg, encryptedMsg[end:]...)
+	}
+	return editedMsg, nil
+}
+
+// CTREditOracle encrypts msgs in CTR mode under a random key and exposes the
+// edit function to its users.
+type CTREditOracle struct {
+	c     cipher.Block
+	nonce uint64
+}
+
+// NewCTREditOracle returns a CTREditOracle with a random key
+func NewCTREditOracle() (*CTREditOracle, error) {
+	key, err := GenerateRandomBytes(16)
+	if err != nil {
+		return nil, err
+	}
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &CTREditOracle{c: c}, nil
+}
+
+// Encrypt returns msg encrypted
+func (o *CTREditOracle) Encrypt(msg []byte) ([]byte, error) {
+	return CTRCrypt(msg, o.c, o.nonce)
+}
+
+// Edit replaces the bytes from offset of the encrypted msg by newText
+func (o *CTREditOracle) Edit(encryptedMsg []byte, offset int, newText []byte) ([]byte, error) {
+	return CTREdit(encryptedMsg, o.c, o.nonce, offset, newText)
+}
+
+// RecoverCTRWithEdit recovers the msg encrypted in CTR mode by editing it with
+// zeros, which gives away the keystream.
+func RecoverCTRWithEdit(edit func(encryptedMsg []byte, offset int, newText []byte) ([]byte, error), encryptedMsg []byte) ([]byte, error) {
+	keystream, err := edit(encryptedMsg, 0, make([]byte, len(encryptedMsg)))
+	if err != nil {
+		return nil, err
+	}
+	msg := make([]byte, len(encryptedMsg))
+	XORBytes(msg, encryptedMsg, keystream)
+	return msg, nil
+}
+
+// CTRUserData encrypts quoted user data surrounded by a prefix and a suffix
+// with AES in CTR mode under a random key and nonce.
+type CTRUserData struct {
+	prefix, suffix []byte
+	nonce          uint64
+	c              cipher.Block
+}
+
+// NewCTRUserData creates a CTRUserData with the challenge 16 prefix and suffix
+func NewCTRUserData() (*CTRUserData, error) {
+	key, err := GenerateRandomBytes(16)
+	if err != nil {
+		return nil, err
+	}
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &CTRUserData{
+		prefix: userDataPrefix,
+		suffix: userDataSuffix,
+		nonce:  uint64(mrand.Int63()),
+		c:      c,
+	}, nil
+}
+
+// Encrypt returns prefix || quoted userData || suffix encrypted
+func (u *CTRUserData) Encrypt(userData []byte) ([]byte, error) {
+	msg := buildUserDataMsg(u.prefix, userData, u.suffix)
+	return CTRCrypt(msg, u.c, u.nonce)
+}
+
+// IsAdmin decrypts the msg and looks for ";admin=true;"
+func (u *CTRUserData) IsAdmin(encryptedMsg []byte) (bool, error) {
+	msg, err := CTRCrypt(encryptedMsg, u.c, u.nonce)
+	if err != nil {
+		return false, err
+	}
+	return isAdminUserData(msg), nil
+}
+
+// CTRBitflip returns a copy of the msg encrypted with a stream cipher where the
+// bytes at offset, known to decrypt to known, are flipped to decrypt to target.
+func CTRBitflip(encryptedMsg []byte, offset int, known, target []byte) ([]byte, error) {
+	if len(known) != len(target) {
+		return nil, fmt.Errorf("known and target must have the same length")
+	}
+	if offset < 0 || offset+len(target) > len(encryptedMsg) {
+		return nil, fmt.Errorf("target goes past the end of the encrypted msg")
+	}
+	forgedMsg := append([]byte{}, encryptedMsg...)
+	delta := make([]byte, len(target))
+	XORBytes(delta, known, target)
+	XORBytes(forgedMsg[offset:], forgedMsg[offset:], delta)
+	return forgedMsg, nil
+}
+
+// HighASCIIError is returned when a decrypted msg contains bytes above 127
+type HighASCIIError struct {
+	Msg []byte
+}
+
+func (e *HighASCIIError) Error() string {
+	return fmt.Sprintf("invalid high ASCII values in %q", e.Msg)
+}
+
+// CBCKeyAsIV encrypts msgs with AES in CBC mode using the key as iv
+type CBCKeyAsIV struct {
+	key []byte
+	c   cipher.Block
+}
+
+// NewCBCKeyAsIV returns a CBCKeyAsIV with a random key
+func NewCBCKeyAsIV() (*CBCKeyAsIV, error) {
+	key, err := GenerateRandomBytes(16)
+	if err != nil {
+		return nil, err
+	}
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &CBCKeyAsIV{key: key, c: c}, nil
+}
+
+// Encrypt returns msg encrypted
+func (o *CBCKeyAsIV) Encrypt(msg []byte) ([]byte, error) {
+	return CBCPadEncrypt(msg, o.key, o.c)
+}
+
+// Decrypt decrypts the msg and returns a HighASCIIError containing the
+// decrypted msg if it isn't ASCII compliant.
+func (o *CBCKeyAsIV) Decrypt(encryptedMsg []byte) error {
+	msg := CBCDecrypter(encryptedMsg, o.key, o.c)
+	for _, b := range msg {
+		if b > 127 {
+			return &HighASCIIError{Msg: msg}
+		}
+	}
+	_, err := Pkcs7Unpad(msg, o.c.BlockSize())
+	return err
+}
+
+// RecoverCBCKeyAsIV recovers the key used as iv by decrypting C1 || 0 || C1.
+// The decryption of the third block is P1 ^ key.
+func RecoverCBCKeyAsIV(encrypt func([]byte) ([]byte, error), decrypt func([]byte) error, blockSize int) ([]byte, error) {
+	encryptedMsg, err := encrypt(bytes.Repeat([]byte("A"), 3*blockSize))
+	if err != nil {
+		return nil, err
+	}
+	forgedMsg := make([]byte, 0, 3*blockSize)
+	forgedMsg = append(forgedMsg, encryptedMsg[:blockSize]...)
+	forgedMsg = append(forgedMsg, make([]byte, blockSize)...)
+	forgedMsg = append(forgedMsg, encryptedMsg[:blockSize]...)
+
+	var highASCIIErr *HighASCIIError
+	if err := decrypt(forgedMsg); !errors.As(err, &highASCIIErr) {
+		return nil, fmt.Errorf("Decryption did not leak the msg: %v", err)
+	}
+	msg := highASCIIErr.Msg
+	key := make([]byte, blockSize)
+	XORBytes(key, msg[:blockSize], msg[2*blockSize:3*blockSize])
+	return key, nil
+}
+
+// MDPadding returns the Merkle-Damgard padding appended to a msg of length
+// bytes by the hash functions using 64 bytes blocks: 0x80, zeros up to 56
+// bytes modulo 64, then the length in bits on 64 bits in the given order.
+func MDPadding(length uint64, order binary.ByteOrder) []byte {
+	npad := 64 - int((length+8)%64)
+	padding := make([]byte, npad+8)
+	padding[0] = 0x80
+	order.PutUint64(padding[npad:], length*8)
+	return padding
+}
+
+// SHA1 computes SHA-1 digests. Unlike crypto/sha1 its registers and the
+// length of the msg already processed can be set, which is what length
+// extension attacks need.
+type SHA1 struct {
+	h      [5]uint32
+	block  []byte
+	length uint64
+}
+
+var sha1Init = [5]uint32{0x67452301, 0xefcdab89, 0x98badcfe, 0x10325476, 0xc3d2e1f0}
+
+// SHA1 digest and block sizes
+const (
+	SHA1Size      = 20
+	SHA1BlockSize = 64
+)
+
+// NewSHA1 returns a SHA1 ready to hash a new msg
+func NewSHA1() *SHA1 {
+	return NewSHA1FromState(sha1Init, 0)
+}
+
+// NewSHA1FromState returns a SHA1 whose registers are h and which has already
+// processed length bytes. length must be a multiple of the block size.
+func NewSHA1FromState(h [5]uint32, length uint64) *SHA1 {
+	return &SHA1{h: h, block: make([]byte, 0, SHA1BlockSize), length: length}
+}
+
+// Reset resets the SHA1 to hash a new msg
+func (sha *SHA1) Reset() {
+	sha.h = sha1Init
+	sha.block = sha.block[:0]
+	sha.length = 0
+}
+
+// Size returns the size of the digest
+func (sha *SHA1) Size() int { return SHA1Size }
+
+// BlockSize returns the size of the blocks processed
+func (sha *SHA1) BlockSize() int { return SHA1BlockSize }
+
+// Write adds p to the msg being hashed
+func (sha *SHA1) Write(p []byte) (int, error) {
+	n := len(p)
+	sha.length += uint64(n)
+	for len(p) > 0 {
+		m := copy(sha.block[len(sha.block):SHA1BlockSize], p)
+		sha.block = sha.block[:len(sha.block)+m]
+		p = p[m:]
+		if len(sha.block) == SHA1BlockSize {
+			sha.processBlock(sha.block)
+			sha.block = sha.block[:0]
+		}
+	}
+	return n, nil
+}
+
+// Sum appends the digest of the msg to b without changing the state
+func (sha *SHA1) Sum(b []byte) []byte {
+	d0 := *sha
+	d0.block = append(make([]byte, 0, SHA1BlockSize), sha.block...)
+	d0.Write(MDPadding(sha.length, binary.BigEndian))
+
+	digest := make([]byte, SHA1Size)
+	for i, h := range d0.h {
+		binary.BigEndian.PutUint32(digest[i*4:], h)
+	}
+	return append(b, digest...)
+}
+
+func (sha *SHA1) processBlock(block []byte) {
+	var w [80]uint32
+	for i := 0; i < 16; i++ {
+		w[i] = binary.BigEndian.Uint32(block[i*4:])
+	}
+	for i := 16; i < 80; i++ {
+		w[i] = bits.RotateLeft32(w[i-3]^w[i-8]^w[i-14]^w[i-16], 1)
+	}
+
+	a, b, c, d, e := sha.h[0], sha.h[1], sha.h[2], sha.h[3], sha.h[4]
+	for i := 0; i < 80; i++ {
+		var f, k uint32
+		switch {
+		case i < 20:
+			f, k = (b&c)|(^b&d), 0x5a827999
+		case i < 40:
+			f, k = b^c^d, 0x6ed9eba1
+		case i < 60:
+			f, k = (b&c)|(b&d)|(c&d), 0x8f1bbcdc
+		default:
+			f, k = b^c^d, 0xca62c1d6
+		}
+		tmp := bits.RotateLeft32(a, 5) + f + e + k + w[i]
+		a, b, c, d, e = tmp, a, bits.RotateLeft32(b, 30), c, d
+	}
+	sha.h[0] += a
+	sha.h[1] += b
+	sha.h[2] += c
+	sha.h[3] += d
+	sha.h[4] += e
+}
+
+// SHA1Sum returns the SHA-1 digest of msg
+func SHA1Sum(msg []byte) []byte {
+	d := NewSHA1()
+	d.Write(msg)
+	return d.Sum(nil)
+}
+
+// SHA1MAC returns the secret prefix MAC SHA1(key || msg)
+func SHA1MAC(key, msg []byte) []byte {
+	d := NewSHA1()
+	d.Write(key)
+	d.Write(msg)
+	return d.Sum(nil)
+}
+
+// VerifySHA1MAC returns true if mac is the secret prefix MAC of msg
+func VerifySHA1MAC(key, msg, mac []byte) bool {
+	return subtle.ConstantTimeCompare(SHA1MAC(key, msg), mac) == 1
+}
+
+// sha1Registers returns the registers of a SHA1 which produced digest
+func sha1Registers(digest []byte) [5]uint32 {
+	var h [5]uint32
+	for i := range h {
+		h[i] = binary.BigEndian.Uint32(digest[i*4:])
+	}
+	return h
+}
+
+// ForgeSHA1MAC returns msg || glue padding || extension and its secret prefix
+// MAC, computed from the MAC of msg for a key of keyLength bytes.
+func ForgeSHA1MAC(msg, mac, extension []byte, keyLength int) ([]byte, []byte) {
+	glue := MDPadding(uint64(keyLength+len(msg)), binary.BigEndian)
+	forgedMsg := make([]byte, 0, len(msg)+len(glue)+len(extension))
+	forgedMsg = append(forgedMsg, msg...)
+	forgedMsg = append(forgedMsg, glue...)
+	forgedMsg = append(forgedMsg, extension...)
+
+	d := NewSHA1FromState(sha1Registers(mac), uint64(keyLength+len(msg)+len(glue)))
+	d.Write(extension)
+	return forgedMsg, d.Sum(nil)
+}
+
+// SHA1LengthExtension forges a valid MAC for msg || glue padding || extension
+// by trying the key lengths from minKeyLength to maxKeyLength against verify.
+func SHA1LengthExtension(msg, mac, extension []byte, minKeyLength, maxKeyLength int, verify func(msg, mac []byte) bool) ([]byte, []byte, error) {
+	forge := func(keyLength int) ([]byte, []byte) {
+		return ForgeSHA1MAC(msg, mac, extension, keyLength)
+	}
+	return bruteForceKeyLength(forge, minKeyLength, maxKeyLength, verify)
+}
+
+// bruteForceKeyLength returns the first msg and MAC forged for a key length
+// between minKeyLength and maxKeyLength accepted by verify.
+func bruteForceKeyLength(forge func(keyLength int) ([]byte, []byte), minKeyLength, maxKeyLength int, verify func(msg, mac []byte) bool) ([]byte, []byte, error) {
+	for keyLength := minKeyLength; keyLength <= maxKeyLength; keyLength++ {
+		forgedMsg, forgedMAC := forge(keyLength)
+		if verify(forgedMsg, forgedMAC) {
+			return forgedMsg, forgedMAC, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("No key length between %d and %d gives a valid MAC", minKeyLength, maxKeyLength)
+}
+
+// MD4 computes MD4 digests and, like SHA1, can resume from any state.
+type MD4 struct {
+	h      [4]uint32
+	block  []byte
+	length uint64
+}
+
+var md4Init = [4]uint32{0x67452301, 0xefcdab89, 0x98badcfe, 0x10325476}
+
+// MD4 digest and block sizes
+const (
+	MD4Size      = 16
+	MD4BlockSize = 64
+)
+
+// NewMD4 returns a MD4 ready to hash a new msg
+func NewMD4() *MD4 {
+	return NewMD4FromState(md4Init, 0)
+}
+
+// NewMD4FromState returns a MD4 whose registers are h and which has already
+// processed length bytes. length must be a multiple of the block size.
+func NewMD4FromState(h [4]uint32, length uint64) *MD4 {
+	return &MD4{h: h, block: make([]byte, 0, MD4BlockSize), length: length}
+}
+
+// Reset resets the MD4 to hash a new msg
+func (md *MD4) Reset() {
+	md.h = md4Init
+	md.block = md.block[:0]
+	md.length = 0
+}
+
+// Size returns the size of the digest
+func (md *MD4) Size() int { return MD4Size }
+
+// BlockSize returns the size of the blocks processed
+func (md *MD4) BlockSize() int { return MD4BlockSize }
+
+// Write adds p to the msg being hashed
+func (md *MD4) Write(p []byte) (int, error) {
+	n := len(p)
+	md.length += uint64(n)
+	for len(p) > 0 {
+		m := copy(md.block[len(md.block):MD4BlockSize], p)
+		md.block = md.block[:len(md.block)+m]
+		p = p[m:]
+		if len(md.block) == MD4BlockSize {
+			md.processBlock(md.block)
+			md.block = md.block[:0]
+		}
+	}
+	return n, nil
+}
+
+// Sum appends the digest of the msg to b without changing the state
+func (md *MD4) Sum(b []byte) []byte {
+	d0 := *md
+	d0.block = append(make([]byte, 0, MD4BlockSize), md.block...)
+	d0.Write(MDPadding(md.length, binary.LittleEndian))
+
+	digest := make([]byte, MD4Size)
+	for i, h := range d0.h {
+		binary.LittleEndian.PutUint32(digest[i*4:], h)
+	}
+	return append(b, digest...)
+}
+
+var (
+	md4Round2Order = [16]int{0, 4, 8, 12, 1, 5, 9, 13, 2, 6, 10, 14, 3, 7, 11, 15}
+	md4Round3Order = [16]int{0, 8, 4, 12, 2, 10, 6, 14, 1, 9, 5, 13, 3, 11, 7, 15}
+	md4Shifts      = [3][4]int{{3, 7, 11, 19}, {3, 5, 9, 13}, {3, 9, 11, 15}}
+)
+
+func (md *MD4) processBlock(block []byte) {
+	var x [16]uint32
+	for i := range x {
+		x[i] = binary.LittleEndian.Uint32(block[i*4:])
+	}
+
+	a, b, c, d := md.h[0], md.h[1], md.h[2], md.h[3]
+	for i := 0; i < 16; i++ {
+		f := (b & c) | (^b & d)
+		a = bits.RotateLeft32(a+f+x[i], md4Shifts[0][i%4])
+		a, b, c, d = d, a, b, c
+	}
+	for i := 0; i < 16; i++ {
+		g := (b & c) | (b & d) | (c & d)
+		a = bits.RotateLeft32(a+g+x[md4Round2Order[i]]+0x5a827999, md4Shifts[1][i%4])
+		a, b, c, d = d, a, b, c
+	}
+	for i := 0; i < 16; i++ {
+		h := b ^ c ^ d
+		a = bits.RotateLeft32(a+h+x[md4Round3Order[i]]+0x6ed9eba1, md4Shifts[2][i%4])
+		a, b, c, d = d, a, b, c
+	}
+	md.h[0] += a
+	md.h[1] += b
+	md.h[2] += c
+	md.h[3] += d
+}
+
+// MD4Sum returns the MD4 digest of msg
+func MD4Sum(msg []byte) []byte {
+	d := NewMD4()
+	d.Write(msg)
+	return d.Sum(nil)
+}
+
+// MD4MAC returns the secret prefix MAC MD4(key || msg)
+func MD4MAC(key, msg []byte) []byte {
+	d := NewMD4()
+	d.Write(key)
+	d.Write(msg)
+	return d.Sum(nil)
+}
+
+// VerifyMD4MAC returns true if mac is the secret prefix MAC of msg
+func VerifyMD4MAC(key, msg, mac []byte) bool {
+	return subtle.ConstantTimeCompare(MD4MAC(key, msg), mac) == 1
+}
+
+// md4Registers returns the registers of a MD4 which produced digest
+func md4Registers(digest []byte) [4]uint32 {
+	var h [4]uint32
+	for i := range h {
+		h[i] = binary.LittleEndian.Uint32(digest[i*4:])
+	}
+	return h
+}
+
+// ForgeMD4MAC returns msg || glue padding || extension and its secret prefix
+// MAC, computed from the MAC of msg for a key of keyLength bytes.
+func ForgeMD4MAC(msg, mac, extension []byte, keyLength int) ([]byte, []byte) {
+	glue := MDPadding(uint64(keyLength+len(msg)), binary.LittleEndian)
+	forgedMsg := make([]byte, 0, len(msg)+len(glue)+len(extension))
+	forgedMsg = append(forgedMsg, msg...)
+	forgedMsg = append(forgedMsg, glue...)
+	forgedMsg = append(forgedMsg, extension...)
+
+	d := NewMD4FromState(md4Registers(mac), uint64(keyLength+len(msg)+len(glue)))
+	d.Write(extension)
+	return forgedMsg, d.Sum(nil)
+}
+
+// MD4LengthExtension forges a valid MAC for msg || glue padding || extension
+// by trying the key lengths from minKeyLength to maxKeyLength against verify.
+func MD4LengthExtension(msg, mac, extension []byte, minKeyLength, maxKeyLength int, verify func(msg, mac []byte) bool) ([]byte, []byte, error) {
+	forge := func(keyLength int) ([]byte, []byte) {
+		return ForgeMD4MAC(msg, mac, extension, keyLength)
+	}
+	return bruteForceKeyLength(forge, minKeyLength, maxKeyLength, verify)
+}
+
+// HMACSHA1 returns the HMAC of msg built on our SHA1
+func HMACSHA1(key, msg []byte) []byte {
+	mac := hmac.New(func() hash.Hash { return NewSHA1() }, key)
+	mac.Write(msg)
+	return mac.Sum(nil)
+}
+
+// insecureCompare compares a and b byte by byte, sleeping for delay after
+// each matching byte and returning early on the first difference.
+func insecureCompare(a, b []byte, delay time.Duration) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+		time.Sleep(delay)
+	}
+	return true
+}
+
+// HMACTimingServer is a http.Handler checking the HMAC-SHA1 signature of a
+// file on /test?file=...&signature=... with a comparison leaking timings.
+type HMACTimingServer struct {
+	key   []byte
+	delay time.Duration
+	size  int
+}
+
+// NewHMACTimingServer returns a HMACTimingServer with a random key sleeping
+// for delay after each matching byte of the signature. The signature is the
+// HMAC truncated to size bytes, to make the attack shorter.
+func NewHMACTimingServer(delay time.Duration, size int) (*HMACTimingServer, error) {
+	if size <= 0 || size > SHA1Size {
+		return nil, fmt.Errorf("size must be between 1 and %d, got %d", SHA1Size, size)
+	}
+	key, err := GenerateRandomBytes(16)
+	if err != nil {
+		return nil, err
+	}
+	return &HMACTimingServer{key: key, delay: delay, size: size}, nil
+}
+
+// signature returns the HMAC of file truncated to the size of the server
+func (s *HMACTimingServer) signature(file string) []byte {
+	return HMACSHA1(s.key, []byte(file))[:s.size]
+}
+
+func (s *HMACTimingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/test" {
+		http.NotFound(w, r)
+		return
+	}
+	file := r.URL.Query().Get("file")
+	signature, err := hex.DecodeString(r.URL.Query().Get("signature"))
+	if err != nil {
+		http.Error(w, "Invalid signature", http.StatusBadRequest)
+		return
+	}
+	if !insecureCompare(s.signature(file), signature, s.delay) {
+		http.Error(w, "Invalid signature", http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintln(w, "OK")
+}
+
+// RecoverHMACByTiming recovers the size bytes signature of file one byte at
+// a time by picking the byte for which the server, at baseURL, takes the
+// longest to answer. Each byte is timed samples times on the wall clock and
+// the median is kept. The last byte is the one the server accepts.
+func RecoverHMACByTiming(client *http.Client, baseURL, file string, size, samples int) ([]byte, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("size must be positive, got %d", size)
+	}
+	if samples <= 0 {
+		return nil, fmt.Errorf("samples must be positive, got %d", samples)
+	}
+	signature := make([]byte, size)
+	durations := make([][]time.Duration, 256)
+	for b := range durations {
+		durations[b] = make([]time.Duration, samples)
+	}
+	for i := 0; i < size; i++ {
+		rounds := samples
+		if i == size-1 {
+			// no need to time the last byte, the right one is accepted
+			rounds = 1
+		}
+		// time every byte once per round so that a slow period of the
+		// server does not favor the bytes timed during it
+		for j := 0; j < rounds; j++ {
+			for b := range durations {
+				signature[i] = byte(b)
+				start := time.Now()
+				ok, err := checkSignature(client, baseURL, file, signature)
+				if err != nil {
+					return nil, err
+				}
+				if ok {
+					return signature, nil
+				}
+				durations[b][j] = time.Since(start)
+			}
+		}
+		var (
+			bestByte     byte
+			bestDuration time.Duration
+		)
+		for b := range durations {
+			d := durations[b][:rounds]
+			sort.Slice(d, func(x, y int) bool { return d[x] < d[y] })
+			if median := d[rounds/2]; median > bestDuration {
+				bestDuration = median
+				bestByte = byte(b)
+			}
+		}
+		signature[i] = bestByte
+	}
+	return signature, fmt.Errorf("Could not find a valid signature, best guess %x", signature)
+}
+
+// checkSignature returns true if the server accepts the signature of file
+func checkSignature(client *http.Client, baseURL, file string, signature []byte) (bool, error) {
+	v := url.Values{}
+	v.Set("file", file)
+	v.Set("signature", hex.EncodeToString(signature))
+	resp, err := client.Get(baseURL + "/test?" + v.Encode())
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	return resp.StatusCode == http.StatusOK, nil
+}
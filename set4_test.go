@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	mrand "math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_Challenge25_BreakRandomAccessReadWriteAESCTR(t *testing.T) {
+	encryptedECBMsg, err := base64DecodeFile("data/challenge-data-7.txt")
+	if err != nil {
+		t.Fatal("Could not read or base64 decode", err)
+	}
+	ecbCipher, err := aes.NewCipher([]byte("YELLOW SUBMARINE"))
+	if err != nil {
+		t.Fatal("could not create the aes cipher", err)
+	}
+	msg, err := Pkcs7Unpad(ECBDecrypter(encryptedECBMsg, ecbCipher), ecbCipher.BlockSize())
+	if err != nil {
+		t.Fatal("Could not unpad the decrypted msg", err)
+	}
+
+	oracle, err := NewCTREditOracle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedMsg, err := oracle.Encrypt(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Edit", func(t *testing.T) {
+		for _, c := range []struct {
+			offset  int
+			newText []byte
+		}{
+			{offset: 0, newText: []byte("Hello")},
+			{offset: 17, newText: []byte("YELLOW SUBMARINE YELLOW SUBMARINE")},
+			{offset: len(msg) - 3, newText: []byte("past the end")},
+			{offset: len(msg), newText: []byte("")},
+		} {
+			editedMsg, err := oracle.Edit(encryptedMsg, c.offset, c.newText)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := append(append([]byte{}, msg[:c.offset]...), c.newText...)
+			if end := c.offset + len(c.newText); end < len(msg) {
+				expected = append(expected, msg[end:]...)
+			}
+			got, err := CTRCrypt(editedMsg, oracle.c, oracle.nonce)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, expected) {
+				t.Fatalf("offset = %d ; got = %q ; expected = %q", c.offset, got, expected)
+			}
+		}
+		if _, err := oracle.Edit(encryptedMsg, len(msg)+1, []byte("A")); err == nil {
+			t.Fatal("offset past the end must be rejected")
+		}
+	})
+	t.Run("Recover the msg", func(t *testing.T) {
+		got, err := RecoverCTRWithEdit(oracle.Edit, encryptedMsg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Printf("Decoded msg = \n%s\n\n", got)
+		if !bytes.Equal(got, msg) {
+			t.Fatalf("got = %q ; expected = %q", got, msg)
+		}
+	})
+}
+
+func Test_Challenge26_CTRBitflipping(t *testing.T) {
+	u, err := NewCTRUserData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("Quote user data", func(t *testing.T) {
+		encryptedMsg, err := u.Encrypt([]byte(";admin=true;"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ok, err := u.IsAdmin(encryptedMsg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			t.Fatal("user data is not quoted")
+		}
+	})
+	t.Run("Inject admin", func(t *testing.T) {
+		target := []byte(";admin=true;")
+		placeholder := bytes.Repeat([]byte("A"), len(target))
+		encryptedMsg, err := u.Encrypt(placeholder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		forgedMsg, err := CTRBitflip(encryptedMsg, len(userDataPrefix), placeholder, target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ok, err := u.IsAdmin(forgedMsg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Fatal("Fail to inject ;admin=true;")
+		}
+		fmt.Println("Created an admin user data!!!!")
+	})
+	t.Run("Any offset and target", func(t *testing.T) {
+		target := []byte(";admin=true;role=admin;x=")
+		userData := bytes.Repeat([]byte("A"), 2*len(target))
+		encryptedMsg, err := u.Encrypt(userData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i <= len(userData)-len(target); i++ {
+			offset := len(userDataPrefix) + i
+			forgedMsg, err := CTRBitflip(encryptedMsg, offset, userData[:len(target)], target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg, err := CTRCrypt(forgedMsg, u.c, u.nonce)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(msg[offset:offset+len(target)], target) {
+				t.Fatalf("offset = %d: %q not found in %q", offset, target, msg)
+			}
+		}
+	})
+}
+
+func Test_Challenge27_RecoverKeyFromCBCWithIVEqualKey(t *testing.T) {
+	oracle, err := NewCBCKeyAsIV()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("ASCII msg", func(t *testing.T) {
+		encryptedMsg, err := oracle.Encrypt([]byte("comment1=cooking%20MCs;userdata=foo"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := oracle.Decrypt(encryptedMsg); err != nil {
+			t.Fatal("ASCII msg must decrypt without error", err)
+		}
+	})
+	t.Run("Recover the key", func(t *testing.T) {
+		key, err := RecoverCBCKeyAsIV(oracle.Encrypt, oracle.Decrypt, aes.BlockSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Printf("key = %x\n", key)
+		if !bytes.Equal(key, oracle.key) {
+			t.Fatalf("got = %x ; expected = %x", key, oracle.key)
+		}
+	})
+}
+
+func Test_Challenge28_ImplementSHA1KeyedMAC(t *testing.T) {
+	t.Run("SHA-1 digests", func(t *testing.T) {
+		for i := 0; i < 3*SHA1BlockSize; i++ {
+			msg := bytes.Repeat([]byte("a"), i)
+			expected := sha1.Sum(msg)
+			if got := SHA1Sum(msg); !bytes.Equal(got, expected[:]) {
+				t.Fatalf("len(msg) = %d ; got = %x ; expected = %x", i, got, expected)
+			}
+		}
+		expected := "a9993e364706816aba3e25717850c26c9cd0d89d"
+		if got := fmt.Sprintf("%x", SHA1Sum([]byte("abc"))); got != expected {
+			t.Fatalf("got = %s ; expected = %s", got, expected)
+		}
+	})
+	t.Run("Write in pieces", func(t *testing.T) {
+		msg := bytes.Repeat([]byte("YELLOW SUBMARINE"), 20)
+		var d hash.Hash = NewSHA1()
+		for i := 0; i < len(msg); i += 7 {
+			end := i + 7
+			if end > len(msg) {
+				end = len(msg)
+			}
+			d.Write(msg[i:end])
+			// Sum must not change the state
+			d.Sum(nil)
+		}
+		expected := sha1.Sum(msg)
+		if got := d.Sum(nil); !bytes.Equal(got, expected[:]) {
+			t.Fatalf("got = %x ; expected = %x", got, expected)
+		}
+	})
+	t.Run("Resume from state", func(t *testing.T) {
+		msg := bytes.Repeat([]byte("YELLOW SUBMARINE"), 10)
+		d := NewSHA1()
+		d.Write(msg[:2*SHA1BlockSize])
+		resumed := NewSHA1FromState(d.h, 2*SHA1BlockSize)
+		resumed.Write(msg[2*SHA1BlockSize:])
+		expected := sha1.Sum(msg)
+		if got := resumed.Sum(nil); !bytes.Equal(got, expected[:]) {
+			t.Fatalf("got = %x ; expected = %x", got, expected)
+		}
+	})
+	t.Run("Secret prefix MAC", func(t *testing.T) {
+		key := []byte("YELLOW SUBMARINE")
+		msg := []byte("comment1=cooking%20MCs;userdata=foo;comment2=%20like%20a%20pound%20of%20bacon")
+		mac := SHA1MAC(key, msg)
+		if !VerifySHA1MAC(key, msg, mac) {
+			t.Fatal("MAC must be valid")
+		}
+		tamperedMsg := append([]byte{}, msg...)
+		tamperedMsg[0] ^= 1
+		if VerifySHA1MAC(key, tamperedMsg, mac) {
+			t.Fatal("MAC must be invalid for a tampered msg")
+		}
+		if VerifySHA1MAC([]byte("ORANGE SUBMARINE"), msg, mac) {
+			t.Fatal("MAC must be invalid without the key")
+		}
+	})
+}
+
+func Test_Challenge29_BreakSHA1KeyedMACUsingLengthExtension(t *testing.T) {
+	msg := []byte("comment1=cooking%20MCs;userdata=foo;comment2=%20like%20a%20pound%20of%20bacon")
+	t.Run("MD padding", func(t *testing.T) {
+		for i := 0; i < 3*SHA1BlockSize; i++ {
+			padding := MDPadding(uint64(i), binary.BigEndian)
+			if (i+len(padding))%SHA1BlockSize != 0 {
+				t.Fatalf("len(msg) = %d ; padded length = %d", i, i+len(padding))
+			}
+			if len(padding) < 9 || len(padding) > SHA1BlockSize+8 {
+				t.Fatalf("len(msg) = %d ; len(padding) = %d", i, len(padding))
+			}
+		}
+	})
+	t.Run("Forge admin", func(t *testing.T) {
+		key, err := GenerateRandomBytes(1 + mrand.Intn(32))
+		if err != nil {
+			t.Fatal(err)
+		}
+		mac := SHA1MAC(key, msg)
+		verify := func(msg, mac []byte) bool {
+			return VerifySHA1MAC(key, msg, mac)
+		}
+
+		forgedMsg, forgedMAC, err := SHA1LengthExtension(msg, mac, []byte(";admin=true"), 0, 64, verify)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Printf("forged msg = %q\nforged MAC = %x\n", forgedMsg, forgedMAC)
+		if !bytes.HasPrefix(forgedMsg, msg) || !bytes.HasSuffix(forgedMsg, []byte(";admin=true")) {
+			t.Fatalf("unexpected forged msg %q", forgedMsg)
+		}
+		if !VerifySHA1MAC(key, forgedMsg, forgedMAC) {
+			t.Fatal("forged MAC is not valid")
+		}
+	})
+}
+
+func Test_Challenge30_BreakMD4KeyedMACUsingLengthExtension(t *testing.T) {
+	t.Run("MD4 digests", func(t *testing.T) {
+		cases := []struct {
+			input, expected string
+		}{
+			{input: "", expected: "31d6cfe0d16ae931b73c59d7e0c089c0"},
+			{input: "a", expected: "bde52cb31de33e46245e05fbdbd6fb24"},
+			{input: "abc", expected: "a448017aaf21d8525fc10ae87aa6729d"},
+			{input: "message digest", expected: "d9130a8164549fe818874806e1c7014b"},
+			{input: "abcdefghijklmnopqrstuvwxyz", expected: "d79e1c308aa5bbcdeea8ed63df412da9"},
+			{input: "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789", expected: "043f8582f241db351ce627e153e7f0e4"},
+			{input: "12345678901234567890123456789012345678901234567890123456789012345678901234567890", expected: "e33b4ddc9c38f2199c3e7b164fcc0536"},
+		}
+		for _, c := range cases {
+			if got := fmt.Sprintf("%x", MD4Sum([]byte(c.input))); got != c.expected {
+				t.Fatalf("MD4(%q) got = %s ; expected = %s", c.input, got, c.expected)
+			}
+		}
+	})
+	t.Run("Resume from state", func(t *testing.T) {
+		msg := bytes.Repeat([]byte("YELLOW SUBMARINE"), 10)
+		d := NewMD4()
+		d.Write(msg[:MD4BlockSize])
+		resumed := NewMD4FromState(d.h, MD4BlockSize)
+		resumed.Write(msg[MD4BlockSize:])
+		expected := MD4Sum(msg)
+		if got := resumed.Sum(nil); !bytes.Equal(got, expected) {
+			t.Fatalf("got = %x ; expected = %x", got, expected)
+		}
+	})
+	t.Run("Forge admin", func(t *testing.T) {
+		msg := []byte("comment1=cooking%20MCs;userdata=foo;comment2=%20like%20a%20pound%20of%20bacon")
+		key, err := GenerateRandomBytes(1 + mrand.Intn(32))
+		if err != nil {
+			t.Fatal(err)
+		}
+		mac := MD4MAC(key, msg)
+		verify := func(msg, mac []byte) bool {
+			return VerifyMD4MAC(key, msg, mac)
+		}
+		if !verify(msg, mac) {
+			t.Fatal("MAC must be valid")
+		}
+
+		forgedMsg, forgedMAC, err := MD4LengthExtension(msg, mac, []byte(";admin=true"), 0, 64, verify)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Printf("forged msg = %q\nforged MAC = %x\n", forgedMsg, forgedMAC)
+		if !bytes.HasSuffix(forgedMsg, []byte(";admin=true")) {
+			t.Fatalf("unexpected forged msg %q", forgedMsg)
+		}
+		if !VerifyMD4MAC(key, forgedMsg, forgedMAC) {
+			t.Fatal("forged MAC is not valid")
+		}
+	})
+}
+
+// withJitter simulates the network noise by sleeping for up to jitter before
+// each request is handled.
+func withJitter(h http.Handler, jitter time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Duration(mrand.Int63n(int64(jitter))))
+		h.ServeHTTP(w, r)
+	})
+}
+
+func Test_Challenge31_ImplementAndBreakHMACSHA1WithArtificialTimingLeak(t *testing.T) {
+	t.Run("HMAC-SHA1", func(t *testing.T) {
+		key := []byte("YELLOW SUBMARINE")
+		msg := []byte("foo")
+		expected := hmac.New(sha1.New, key)
+		expected.Write(msg)
+		if got := HMACSHA1(key, msg); !hmac.Equal(got, expected.Sum(nil)) {
+			t.Fatalf("got = %x ; expected = %x", got, expected.Sum(nil))
+		}
+	})
+
+	// the signature is truncated to 2 bytes, timing the 20 bytes of a
+	// HMAC-SHA1 with a 50ms leak takes hours
+	server, err := NewHMACTimingServer(50*time.Millisecond, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	file := "foo"
+
+	t.Run("Reject invalid signature", func(t *testing.T) {
+		invalid := append([]byte{}, server.signature(file)...)
+		invalid[len(invalid)-1] ^= 0xff
+		ok, err := checkSignature(ts.Client(), ts.URL, file, invalid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			t.Fatal("invalid signature must be rejected")
+		}
+		ok, err = checkSignature(ts.Client(), ts.URL, file, server.signature(file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Fatal("valid signature must be accepted")
+		}
+	})
+	t.Run("Invalid arguments", func(t *testing.T) {
+		if _, err := NewHMACTimingServer(time.Millisecond, SHA1Size+1); err == nil {
+			t.Fatal("signature can't be longer than the HMAC")
+		}
+		if _, err := RecoverHMACByTiming(ts.Client(), ts.URL, file, 0, 1); err == nil {
+			t.Fatal("size must be positive")
+		}
+		if _, err := RecoverHMACByTiming(ts.Client(), ts.URL, file, server.size, 0); err == nil {
+			t.Fatal("samples must be positive")
+		}
+	})
+	t.Run("Recover the signature", func(t *testing.T) {
+		signature, err := RecoverHMACByTiming(ts.Client(), ts.URL, file, server.size, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Printf("signature = %x\n", signature)
+		if !bytes.Equal(signature, server.signature(file)) {
+			t.Fatalf("got = %x ; expected = %x", signature, server.signature(file))
+		}
+	})
+}
+
+func Test_Challenge32_BreakHMACSHA1WithSlightlyLessArtificialTimingLeak(t *testing.T) {
+	// the noise on each request is of the same order than the leak
+	server, err := NewHMACTimingServer(5*time.Millisecond, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(withJitter(server, 5*time.Millisecond))
+	defer ts.Close()
+	file := "foo"
+
+	signature, err := RecoverHMACByTiming(ts.Client(), ts.URL, file, server.size, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Printf("signature = %x\n", signature)
+	if !bytes.Equal(signature, server.signature(file)) {
+		t.Fatalf("got = %x ; expected = %x", signature, server.signature(file))
+	}
+}
@@ -0,0 +1,943 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+)
+
+// DHGroup holds the public parameters of a Diffie-Hellman key exchange
+type DHGroup struct {
+	P, G *big.Int
+}
+
+// NISTDHGroup returns the group using the 1536 bits NIST prime and g = 2
+func NISTDHGroup() *DHGroup {
+	p, _ := new(big.Int).SetString("ffffffffffffffffc90fdaa22168c234c4c6628b80dc1cd1"+
+		"29024e088a67cc74020bbea63b139b22514a08798e3404dd"+
+		"ef9519b3cd3a431b302b0a6df25f14374fe1356d6d51c245"+
+		"e485b576625e7ec6f44c42e9a637ed6b0bff5cb6f406b7ed"+
+		"ee386bfb5a899fa5ae9f24117c4b1fe649286651ece45b3d"+
+		"c2007cb8a163bf0598da48361c55d39a69163fa8fd24cf5f"+
+		"83655d23dca3ad961c62f356208552bb9ed529077096966d"+
+		"670c354e4abc9804f1746c08ca237327ffffffffffffffff", 16)
+	return &DHGroup{P: p, G: big.NewInt(2)}
+}
+
+// DHKey is a Diffie-Hellman key pair
+type DHKey struct {
+	Group   *DHGroup
+	Private *big.Int
+	Public  *big.Int
+}
+
+// validate returns an error if p or g is missing or p is not greater than 3
+func (g *DHGroup) validate() error {
+	if g.P == nil || g.G == nil {
+		return fmt.Errorf("invalid group: missing p or g")
+	}
+	if g.P.Cmp(big.NewInt(3)) <= 0 {
+		return fmt.Errorf("invalid group: p must be greater than 3, got %v", g.P)
+	}
+	return nil
+}
+
+// GenerateKey returns a key pair with a random private key in [1, p-1)
+func (g *DHGroup) GenerateKey() (*DHKey, error) {
+	if err := g.validate(); err != nil {
+		return nil, err
+	}
+	max := new(big.Int).Sub(g.P, big.NewInt(2))
+	private, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return nil, err
+	}
+	private.Add(private, big.NewInt(1))
+	return &DHKey{
+		Group:   g,
+		Private: private,
+		Public:  new(big.Int).Exp(g.G, private, g.P),
+	}, nil
+}
+
+// SharedSecret returns peerPublic^private mod p
+func (k *DHKey) SharedSecret(peerPublic *big.Int) *big.Int {
+	return new(big.Int).Exp(peerPublic, k.Private, k.Group.P)
+}
+
+// DHAESKeySHA1 derives an AES-128 key from the first 16 bytes of the SHA-1 of
+// the shared secret.
+func DHAESKeySHA1(secret *big.Int) []byte {
+	return SHA1Sum(secret.Bytes())[:16]
+}
+
+// DHAESKeySHA256 derives an AES-128 key from the first 16 bytes of the SHA-256
+// of the shared secret.
+func DHAESKeySHA256(secret *big.Int) []byte {
+	digest := sha256.Sum256(secret.Bytes())
+	return digest[:16]
+}
+
+// CBCEncryptWithIV encrypts msg with AES in CBC mode under a random iv and
+// returns the encrypted msg followed by the iv.
+func CBCEncryptWithIV(msg, key []byte) ([]byte, error) {
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := GenerateRandomBytes(c.BlockSize())
+	if err != nil {
+		return nil, err
+	}
+	encryptedMsg, err := CBCPadEncrypt(msg, iv, c)
+	if err != nil {
+		return nil, err
+	}
+	return append(encryptedMsg, iv...), nil
+}
+
+// CBCDecryptWithIV decrypts an encrypted msg followed by its iv as returned by
+// CBCEncryptWithIV.
+func CBCDecryptWithIV(data, key []byte) ([]byte, error) {
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 2*c.BlockSize() {
+		return nil, fmt.Errorf("data is too short to hold an encrypted msg and its iv")
+	}
+	encryptedMsg, iv := data[:len(data)-c.BlockSize()], data[len(data)-c.BlockSize():]
+	return CBCDecryptUnpad(encryptedMsg, iv, c)
+}
+
+// DHMessage is a message exchanged by the parties of the Diffie-Hellman
+// protocols. Only the fields used by each step are set.
+type DHMessage struct {
+	P, G   *big.Int
+	Public *big.Int
+	// Data is an AES-CBC encrypted msg followed by its iv
+	Data []byte
+}
+
+// DHMITM intercepts a message sent by A, or by B when fromA is false, and
+// returns the message to forward.
+type DHMITM func(msg DHMessage, fromA bool) DHMessage
+
+// relayDHMessages forwards the messages from in to out through mitm, if any
+func relayDHMessages(in <-chan DHMessage, out chan<- DHMessage, mitm DHMITM, fromA bool) {
+	defer close(out)
+	for msg := range in {
+		if mitm != nil {
+			msg = mitm(msg, fromA)
+		}
+		out <- msg
+	}
+}
+
+// errMissingPublicKey is returned when a message lacks the public key of the peer
+var errMissingPublicKey = errors.New("missing public key")
+
+// receiveDHMessage returns the next message or an error if the peer is gone
+func receiveDHMessage(in <-chan DHMessage) (DHMessage, error) {
+	msg, ok := <-in
+	if !ok {
+		return DHMessage{}, fmt.Errorf("connection closed")
+	}
+	return msg, nil
+}
+
+// runDHEchoProtocol connects A and B, optionally through mitm, and returns
+// the result of A and the error of B.
+func runDHEchoProtocol(alice func(in <-chan DHMessage, out chan<- DHMessage) ([]byte, error), bob func(in <-chan DHMessage, out chan<- DHMessage) error, mitm DHMITM) ([]byte, error) {
+	aOut, bIn := make(chan DHMessage), make(chan DHMessage)
+	bOut, aIn := make(chan DHMessage), make(chan DHMessage)
+	go relayDHMessages(aOut, bIn, mitm, true)
+	go relayDHMessages(bOut, aIn, mitm, false)
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- bob(bIn, bOut)
+	}()
+	echo, err := alice(aIn, aOut)
+	if bobErr := <-errc; bobErr != nil && err == nil {
+		err = fmt.Errorf("B: %v", bobErr)
+	}
+	return echo, err
+}
+
+// dhEchoAlice sends the group and her public key, then msg encrypted with the
+// shared key, and returns the echo decrypted.
+func dhEchoAlice(group *DHGroup, msg []byte) func(in <-chan DHMessage, out chan<- DHMessage) ([]byte, error) {
+	return func(in <-chan DHMessage, out chan<- DHMessage) ([]byte, error) {
+		defer close(out)
+		key, err := group.GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+		out <- DHMessage{P: group.P, G: group.G, Public: key.Public}
+		reply, err := receiveDHMessage(in)
+		if err != nil {
+			return nil, err
+		}
+		if reply.Public == nil {
+			return nil, errMissingPublicKey
+		}
+		return dhEchoSend(in, out, DHAESKeySHA1(key.SharedSecret(reply.Public)), msg)
+	}
+}
+
+// dhEchoSend sends msg encrypted with key and returns the echo decrypted
+func dhEchoSend(in <-chan DHMessage, out chan<- DHMessage, key, msg []byte) ([]byte, error) {
+	data, err := CBCEncryptWithIV(msg, key)
+	if err != nil {
+		return nil, err
+	}
+	out <- DHMessage{Data: data}
+	echo, err := receiveDHMessage(in)
+	if err != nil {
+		return nil, err
+	}
+	return CBCDecryptWithIV(echo.Data, key)
+}
+
+// dhEchoBob uses the group sent by A, replies with his public key and echoes
+// the msg sent by A encrypted under a new iv.
+func dhEchoBob(in <-chan DHMessage, out chan<- DHMessage) error {
+	defer close(out)
+	req, err := receiveDHMessage(in)
+	if err != nil {
+		return err
+	}
+	if req.Public == nil {
+		return errMissingPublicKey
+	}
+	key, err := (&DHGroup{P: req.P, G: req.G}).GenerateKey()
+	if err != nil {
+		return err
+	}
+	out <- DHMessage{Public: key.Public}
+	return dhEchoReply(in, out, DHAESKeySHA1(key.SharedSecret(req.Public)))
+}
+
+// dhEchoReply decrypts the msg received with key and sends it back
+func dhEchoReply(in <-chan DHMessage, out chan<- DHMessage, key []byte) error {
+	req, err := receiveDHMessage(in)
+	if err != nil {
+		return err
+	}
+	msg, err := CBCDecryptWithIV(req.Data, key)
+	if err != nil {
+		return err
+	}
+	data, err := CBCEncryptWithIV(msg, key)
+	if err != nil {
+		return err
+	}
+	out <- DHMessage{Data: data}
+	return nil
+}
+
+// RunDHEcho runs the challenge 34 protocol: A sends the group and her public
+// key, B replies with his public key, A sends msg encrypted with the shared
+// key and B echoes it. The echo received by A is returned.
+func RunDHEcho(group *DHGroup, msg []byte, mitm DHMITM) ([]byte, error) {
+	return runDHEchoProtocol(dhEchoAlice(group, msg), dhEchoBob, mitm)
+}
+
+// DHKeyFixingMITM replaces the public keys by p which makes the shared secret
+// 0 for both parties, then decrypts the msgs it relays.
+type DHKeyFixingMITM struct {
+	// Msgs holds the decrypted msgs
+	Msgs [][]byte
+	// Err holds the last decryption error
+	Err error
+	p   *big.Int
+}
+
+// Intercept implements DHMITM
+func (m *DHKeyFixingMITM) Intercept(msg DHMessage, fromA bool) DHMessage {
+	switch {
+	case msg.P != nil:
+		m.p = msg.P
+		msg.Public = m.p
+	case msg.Public != nil:
+		msg.Public = m.p
+	case msg.Data != nil:
+		decryptedMsg, err := CBCDecryptWithIV(msg.Data, DHAESKeySHA1(big.NewInt(0)))
+		if err != nil {
+			m.Err = err
+		} else {
+			m.Msgs = append(m.Msgs, decryptedMsg)
+		}
+	}
+	return msg
+}
+
+// dhNegotiatedAlice proposes the group, uses the group acknowledged by B, then
+// exchanges the public keys and sends msg encrypted with the shared key.
+func dhNegotiatedAlice(group *DHGroup, msg []byte) func(in <-chan DHMessage, out chan<- DHMessage) ([]byte, error) {
+	return func(in <-chan DHMessage, out chan<- DHMessage) ([]byte, error) {
+		defer close(out)
+		out <- DHMessage{P: group.P, G: group.G}
+		ack, err := receiveDHMessage(in)
+		if err != nil {
+			return nil, err
+		}
+		key, err := (&DHGroup{P: ack.P, G: ack.G}).GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+		out <- DHMessage{Public: key.Public}
+		reply, err := receiveDHMessage(in)
+		if err != nil {
+			return nil, err
+		}
+		if reply.Public == nil {
+			return nil, errMissingPublicKey
+		}
+		return dhEchoSend(in, out, DHAESKeySHA1(key.SharedSecret(reply.Public)), msg)
+	}
+}
+
+// dhNegotiatedBob acknowledges the group proposed by A, then exchanges the
+// public keys and echoes the msg sent by A.
+func dhNegotiatedBob(in <-chan DHMessage, out chan<- DHMessage) error {
+	defer close(out)
+	req, err := receiveDHMessage(in)
+	if err != nil {
+		return err
+	}
+	group := &DHGroup{P: req.P, G: req.G}
+	if err := group.validate(); err != nil {
+		return err
+	}
+	out <- DHMessage{P: group.P, G: group.G}
+	req, err = receiveDHMessage(in)
+	if err != nil {
+		return err
+	}
+	if req.Public == nil {
+		return errMissingPublicKey
+	}
+	key, err := group.GenerateKey()
+	if err != nil {
+		return err
+	}
+	out <- DHMessage{Public: key.Public}
+	return dhEchoReply(in, out, DHAESKeySHA1(key.SharedSecret(req.Public)))
+}
+
+// RunDHNegotiatedEcho runs the challenge 35 protocol: A proposes the group, B
+// acknowledges it, they exchange their public keys and B echoes the msg A
+// sends encrypted with the shared key. The echo received by A is returned.
+func RunDHNegotiatedEcho(group *DHGroup, msg []byte, mitm DHMITM) ([]byte, error) {
+	return runDHEchoProtocol(dhNegotiatedAlice(group, msg), dhNegotiatedBob, mitm)
+}
+
+// DHMaliciousGMITM replaces g by G(p) during the negotiation with both A and B
+// so it can predict the shared secret, then decrypts the msgs it relays.
+type DHMaliciousGMITM struct {
+	// G returns the malicious g for p: 1, p or p-1
+	G func(p *big.Int) *big.Int
+	// Msgs holds the decrypted msgs
+	Msgs [][]byte
+	// Err holds the last decryption error
+	Err error
+
+	p, g    *big.Int
+	publicA *big.Int
+	publicB *big.Int
+}
+
+// Intercept implements DHMITM
+func (m *DHMaliciousGMITM) Intercept(msg DHMessage, fromA bool) DHMessage {
+	switch {
+	case msg.P != nil:
+		m.p = msg.P
+		m.g = m.G(msg.P)
+		msg.G = m.g
+	case msg.Public != nil && fromA:
+		m.publicA = msg.Public
+	case msg.Public != nil:
+		m.publicB = msg.Public
+	case msg.Data != nil:
+		decryptedMsg, err := CBCDecryptWithIV(msg.Data, DHAESKeySHA1(m.predictSecret()))
+		if err != nil {
+			m.Err = err
+		} else {
+			m.Msgs = append(m.Msgs, decryptedMsg)
+		}
+	}
+	return msg
+}
+
+// predictSecret returns the shared secret forced by the malicious g
+func (m *DHMaliciousGMITM) predictSecret() *big.Int {
+	pMinusOne := new(big.Int).Sub(m.p, big.NewInt(1))
+	switch {
+	case new(big.Int).Mod(m.g, m.p).Sign() == 0:
+		// (p^a)^b = 0 mod p
+		return big.NewInt(0)
+	case m.g.Cmp(pMinusOne) == 0 && m.publicA.Cmp(pMinusOne) == 0 && m.publicB.Cmp(pMinusOne) == 0:
+		// (-1)^(ab) = -1 when both a and b are odd
+		return pMinusOne
+	default:
+		// 1^(ab) = 1 and (-1)^(ab) = 1 when a or b is even
+		return big.NewInt(1)
+	}
+}
+
+// ErrSRPAuthentication is returned when the SRP proof of the client is wrong
+var ErrSRPAuthentication = errors.New("srp: authentication failed")
+
+// ErrSRPProtocol is returned when a SRP message lacks a field the step needs
+var ErrSRPProtocol = errors.New("srp: protocol error")
+
+// SRPParams holds the parameters agreed on by the SRP client and server
+type SRPParams struct {
+	N, G, K *big.Int
+}
+
+// NewSRPParams returns the parameters using the NIST prime, g = 2 and k = 3
+func NewSRPParams() *SRPParams {
+	group := NISTDHGroup()
+	return &SRPParams{N: group.P, G: group.G, K: big.NewInt(3)}
+}
+
+// srpMessage is a message exchanged by the SRP client and server. Only the
+// fields used by each step are set.
+type srpMessage struct {
+	Email string   `json:"email,omitempty"`
+	A     *big.Int `json:"A,omitempty"`
+	B     *big.Int `json:"B,omitempty"`
+	U     *big.Int `json:"u,omitempty"`
+	Salt  []byte   `json:"salt,omitempty"`
+	HMAC  []byte   `json:"hmac,omitempty"`
+	OK    bool     `json:"ok,omitempty"`
+}
+
+// sha256Int returns the SHA-256 of the concatenated parts as an integer
+func sha256Int(parts ...[]byte) *big.Int {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return new(big.Int).SetBytes(h.Sum(nil))
+}
+
+// srpProof returns HMAC-SHA256(SHA256(S), salt)
+func srpProof(s *big.Int, salt []byte) []byte {
+	k := sha256.Sum256(s.Bytes())
+	mac := hmac.New(sha256.New, k[:])
+	mac.Write(salt)
+	return mac.Sum(nil)
+}
+
+type srpUser struct {
+	salt []byte
+	v    *big.Int
+}
+
+// SRPServer authenticates clients knowing the password of a registered user
+// without storing the passwords.
+type SRPServer struct {
+	params *SRPParams
+	mu     sync.Mutex
+	users  map[string]srpUser
+}
+
+// NewSRPServer returns a SRPServer without users
+func NewSRPServer(params *SRPParams) *SRPServer {
+	return &SRPServer{params: params, users: make(map[string]srpUser)}
+}
+
+// Register stores a random salt and the verifier v = g^x with x = SHA256(salt || password)
+func (s *SRPServer) Register(email, password string) error {
+	salt, err := GenerateRandomBytes(16)
+	if err != nil {
+		return err
+	}
+	x := sha256Int(salt, []byte(password))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[email] = srpUser{salt: salt, v: new(big.Int).Exp(s.params.G, x, s.params.N)}
+	return nil
+}
+
+// Serve runs one login on conn and returns ErrSRPAuthentication if the client
+// does not prove the knowledge of the password.
+func (s *SRPServer) Serve(conn io.ReadWriter) error {
+	return s.serve(conn, func(v, b, publicA *big.Int) (srpMessage, *big.Int, error) {
+		// B = kv + g^b
+		n := s.params.N
+		publicB := new(big.Int).Mul(s.params.K, v)
+		publicB.Add(publicB, new(big.Int).Exp(s.params.G, b, n))
+		publicB.Mod(publicB, n)
+		return srpMessage{B: publicB}, sha256Int(publicA.Bytes(), publicB.Bytes()), nil
+	})
+}
+
+// serve runs one login on conn. challenge returns the msg holding B sent to
+// the client along with u, given the verifier v, the private b and A.
+func (s *SRPServer) serve(conn io.ReadWriter, challenge func(v, b, publicA *big.Int) (srpMessage, *big.Int, error)) error {
+	enc, dec := json.NewEncoder(conn), json.NewDecoder(conn)
+	var hello srpMessage
+	if err := dec.Decode(&hello); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	user, ok := s.users[hello.Email]
+	s.mu.Unlock()
+	if !ok || hello.A == nil {
+		enc.Encode(srpMessage{})
+		return ErrSRPAuthentication
+	}
+
+	n := s.params.N
+	b, err := rand.Int(rand.Reader, n)
+	if err != nil {
+		return err
+	}
+	msg, u, err := challenge(user.v, b, hello.A)
+	if err != nil {
+		return err
+	}
+	msg.Salt = user.salt
+	if err := enc.Encode(msg); err != nil {
+		return err
+	}
+
+	// S = (A * v^u)^b
+	secret := new(big.Int).Exp(user.v, u, n)
+	secret.Mul(secret, hello.A)
+	secret.Exp(secret, b, n)
+
+	var proof srpMessage
+	if err := dec.Decode(&proof); err != nil {
+		return err
+	}
+	ok = hmac.Equal(proof.HMAC, srpProof(secret, user.salt))
+	if err := enc.Encode(srpMessage{OK: ok}); err != nil {
+		return err
+	}
+	if !ok {
+		return ErrSRPAuthentication
+	}
+	return nil
+}
+
+// SRPClient logs in a SRPServer with an email and a password
+type SRPClient struct {
+	params          *SRPParams
+	email, password string
+}
+
+// NewSRPClient returns a SRPClient for the user email
+func NewSRPClient(params *SRPParams, email, password string) *SRPClient {
+	return &SRPClient{params: params, email: email, password: password}
+}
+
+// Login authenticates with the server on conn and returns
+// ErrSRPAuthentication if the server rejects the proof.
+func (c *SRPClient) Login(conn io.ReadWriter) error {
+	return c.login(conn, func(a, publicA, x *big.Int, challenge srpMessage) (*big.Int, error) {
+		// S = (B - k * g^x)^(a + u * x)
+		n := c.params.N
+		u := sha256Int(publicA.Bytes(), challenge.B.Bytes())
+		base := new(big.Int).Exp(c.params.G, x, n)
+		base.Mul(base, c.params.K)
+		base.Sub(challenge.B, base)
+		base.Mod(base, n)
+		exp := new(big.Int).Mul(u, x)
+		exp.Add(exp, a)
+		return base.Exp(base, exp, n), nil
+	})
+}
+
+// login picks the private a and logs in with srpLogin. secret computes the
+// shared secret from a, A, x = SHA256(salt || password) and the challenge.
+func (c *SRPClient) login(conn io.ReadWriter, secret func(a, publicA, x *big.Int, challenge srpMessage) (*big.Int, error)) error {
+	n := c.params.N
+	a, err := rand.Int(rand.Reader, n)
+	if err != nil {
+		return err
+	}
+	publicA := new(big.Int).Exp(c.params.G, a, n)
+	return srpLogin(conn, c.email, publicA, func(challenge srpMessage) (*big.Int, error) {
+		x := sha256Int(challenge.Salt, []byte(c.password))
+		return secret(a, publicA, x, challenge)
+	})
+}
+
+// srpLogin sends email and A, computes the shared secret from the challenge
+// sent by the server and sends the proof of its knowledge.
+func srpLogin(conn io.ReadWriter, email string, publicA *big.Int, secret func(challenge srpMessage) (*big.Int, error)) error {
+	enc, dec := json.NewEncoder(conn), json.NewDecoder(conn)
+	if err := enc.Encode(srpMessage{Email: email, A: publicA}); err != nil {
+		return err
+	}
+	var challenge srpMessage
+	if err := dec.Decode(&challenge); err != nil {
+		return err
+	}
+	if challenge.B == nil {
+		return ErrSRPAuthentication
+	}
+	s, err := secret(challenge)
+	if err != nil {
+		return err
+	}
+	proof := srpProof(s, challenge.Salt)
+	if err := enc.Encode(srpMessage{HMAC: proof}); err != nil {
+		return err
+	}
+	var result srpMessage
+	if err := dec.Decode(&result); err != nil {
+		return err
+	}
+	if !result.OK {
+		return ErrSRPAuthentication
+	}
+	return nil
+}
+
+// SRPZeroKeyClient logs in a SRPServer without the password by sending a
+// multiple of N as A, which makes the secret computed by the server 0.
+type SRPZeroKeyClient struct {
+	email string
+	a     *big.Int
+}
+
+// NewSRPZeroKeyClient returns a SRPZeroKeyClient sending A = multiple * N
+func NewSRPZeroKeyClient(params *SRPParams, email string, multiple int64) *SRPZeroKeyClient {
+	a := new(big.Int).Mul(params.N, big.NewInt(multiple))
+	return &SRPZeroKeyClient{email: email, a: a}
+}
+
+// Login authenticates with the server on conn
+func (c *SRPZeroKeyClient) Login(conn io.ReadWriter) error {
+	return srpLogin(conn, c.email, c.a, func(challenge srpMessage) (*big.Int, error) {
+		// S = (A * v^u)^b = 0 mod N
+		return big.NewInt(0), nil
+	})
+}
+
+// SimpleSRPServer authenticates clients with the simplified SRP where
+// B = g^b and u is a random 128 bits number.
+type SimpleSRPServer struct {
+	*SRPServer
+}
+
+// NewSimpleSRPServer returns a SimpleSRPServer without users
+func NewSimpleSRPServer(params *SRPParams) *SimpleSRPServer {
+	return &SimpleSRPServer{SRPServer: NewSRPServer(params)}
+}
+
+// Serve runs one login on conn and returns ErrSRPAuthentication if the client
+// does not prove the knowledge of the password.
+func (s *SimpleSRPServer) Serve(conn io.ReadWriter) error {
+	return s.serve(conn, func(v, b, publicA *big.Int) (srpMessage, *big.Int, error) {
+		u, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+		if err != nil {
+			return srpMessage{}, nil, err
+		}
+		publicB := new(big.Int).Exp(s.params.G, b, s.params.N)
+		return srpMessage{B: publicB, U: u}, u, nil
+	})
+}
+
+// SimpleSRPClient logs in a SimpleSRPServer with an email and a password
+type SimpleSRPClient struct {
+	*SRPClient
+}
+
+// NewSimpleSRPClient returns a SimpleSRPClient for the user email
+func NewSimpleSRPClient(params *SRPParams, email, password string) *SimpleSRPClient {
+	return &SimpleSRPClient{SRPClient: NewSRPClient(params, email, password)}
+}
+
+// Login authenticates with the server on conn and returns
+// ErrSRPAuthentication if the server rejects the proof.
+func (c *SimpleSRPClient) Login(conn io.ReadWriter) error {
+	return c.login(conn, func(a, publicA, x *big.Int, challenge srpMessage) (*big.Int, error) {
+		if challenge.B == nil || challenge.U == nil {
+			return nil, fmt.Errorf("%w: missing B or u", ErrSRPProtocol)
+		}
+		// S = B^(a + u * x)
+		exp := new(big.Int).Mul(challenge.U, x)
+		exp.Add(exp, a)
+		return new(big.Int).Exp(challenge.B, exp, c.params.N), nil
+	})
+}
+
+// SimpleSRPMITM poses as a SimpleSRPServer with b = 1 and u = 1, so the
+// secret of the client is A * v, and captures the proof of the client to
+// crack the password offline.
+type SimpleSRPMITM struct {
+	params *SRPParams
+	salt   []byte
+	a      *big.Int
+	proof  []byte
+}
+
+// NewSimpleSRPMITM returns a SimpleSRPMITM sending a random salt
+func NewSimpleSRPMITM(params *SRPParams) (*SimpleSRPMITM, error) {
+	salt, err := GenerateRandomBytes(16)
+	if err != nil {
+		return nil, err
+	}
+	return &SimpleSRPMITM{params: params, salt: salt}, nil
+}
+
+// Serve captures A and the proof of the client on conn, then rejects it
+func (m *SimpleSRPMITM) Serve(conn io.ReadWriter) error {
+	enc, dec := json.NewEncoder(conn), json.NewDecoder(conn)
+	var hello srpMessage
+	if err := dec.Decode(&hello); err != nil {
+		return err
+	}
+	// B = g^1 and u = 1
+	if err := enc.Encode(srpMessage{Salt: m.salt, B: m.params.G, U: big.NewInt(1)}); err != nil {
+		return err
+	}
+	var proof srpMessage
+	if err := dec.Decode(&proof); err != nil {
+		return err
+	}
+	m.a, m.proof = hello.A, proof.HMAC
+	return enc.Encode(srpMessage{OK: false})
+}
+
+// Crack returns the password of wordlist matching the captured proof, trying
+// the words in parallel on workers goroutines.
+func (m *SimpleSRPMITM) Crack(wordlist []string, workers int) (string, error) {
+	if m.a == nil || m.proof == nil {
+		return "", fmt.Errorf("No proof captured")
+	}
+	if workers <= 0 {
+		return "", fmt.Errorf("workers must be positive, got %d", workers)
+	}
+	words := make(chan string)
+	found := make(chan string, workers)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for word := range words {
+				if m.isPassword(word) {
+					found <- word
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(words)
+		for _, word := range wordlist {
+			select {
+			case words <- word:
+			case <-done:
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(found)
+	}()
+
+	password, ok := <-found
+	close(done)
+	// let the workers finish
+	for range found {
+	}
+	if !ok {
+		return "", fmt.Errorf("password not in the wordlist")
+	}
+	return password, nil
+}
+
+// isPassword returns true if the proof captured was computed with password
+func (m *SimpleSRPMITM) isPassword(password string) bool {
+	n := m.params.N
+	// S = B^(a + x) = A * g^x = A * v
+	x := sha256Int(m.salt, []byte(password))
+	secret := new(big.Int).Exp(m.params.G, x, n)
+	secret.Mul(secret, m.a)
+	secret.Mod(secret, n)
+	return hmac.Equal(srpProof(secret, m.salt), m.proof)
+}
+
+// InvMod returns the inverse of a modulo m computed with the extended
+// Euclidean algorithm.
+func InvMod(a, m *big.Int) (*big.Int, error) {
+	if m.Sign() <= 0 {
+		return nil, fmt.Errorf("modulus must be positive")
+	}
+	// invariants: oldR = oldS * a mod m and r = s * a mod m
+	oldR, r := new(big.Int).Mod(a, m), new(big.Int).Set(m)
+	oldS, s := big.NewInt(1), big.NewInt(0)
+	for r.Sign() != 0 {
+		q := new(big.Int).Div(oldR, r)
+		oldR, r = r, new(big.Int).Sub(oldR, new(big.Int).Mul(q, r))
+		oldS, s = s, new(big.Int).Sub(oldS, new(big.Int).Mul(q, s))
+	}
+	if oldR.Cmp(big.NewInt(1)) != 0 {
+		return nil, fmt.Errorf("%v is not invertible modulo %v", a, m)
+	}
+	return oldS.Mod(oldS, m), nil
+}
+
+// RSAPublicKey is a textbook RSA public key
+type RSAPublicKey struct {
+	N, E *big.Int
+}
+
+// RSAPrivateKey is a textbook RSA private key
+type RSAPrivateKey struct {
+	RSAPublicKey
+	D *big.Int
+}
+
+// GenerateRSAKey returns a key whose modulus is the product of 2 random
+// primes of bits/2 bits and whose public exponent is e.
+func GenerateRSAKey(bits int, e int64) (*RSAPrivateKey, error) {
+	// (p-1)(q-1) is even, an even e never has an inverse
+	if e < 3 || e%2 == 0 {
+		return nil, fmt.Errorf("e must be odd and at least 3, got %d", e)
+	}
+	bigE := big.NewInt(e)
+	one := big.NewInt(1)
+	for {
+		p, err := rand.Prime(rand.Reader, bits/2)
+		if err != nil {
+			return nil, err
+		}
+		q, err := rand.Prime(rand.Reader, bits-bits/2)
+		if err != nil {
+			return nil, err
+		}
+		if p.Cmp(q) == 0 {
+			continue
+		}
+		et := new(big.Int).Mul(new(big.Int).Sub(p, one), new(big.Int).Sub(q, one))
+		d, err := InvMod(bigE, et)
+		if err != nil {
+			// e and (p-1)(q-1) are not coprime, try other primes
+			continue
+		}
+		return &RSAPrivateKey{
+			RSAPublicKey: RSAPublicKey{N: new(big.Int).Mul(p, q), E: bigE},
+			D:            d,
+		}, nil
+	}
+}
+
+// Encrypt returns m^e mod n
+func (k *RSAPublicKey) Encrypt(m *big.Int) *big.Int {
+	return new(big.Int).Exp(m, k.E, k.N)
+}
+
+// Decrypt returns c^d mod n
+func (k *RSAPrivateKey) Decrypt(c *big.Int) *big.Int {
+	return new(big.Int).Exp(c, k.D, k.N)
+}
+
+// StringToInt returns the integer whose big endian bytes are s
+func StringToInt(s string) *big.Int {
+	return new(big.Int).SetBytes([]byte(s))
+}
+
+// IntToString returns the string made of the big endian bytes of n
+func IntToString(n *big.Int) string {
+	return string(n.Bytes())
+}
+
+// CRT returns the solution x modulo the product of the moduli of the system
+// x = residues[i] mod moduli[i]. The moduli must be pairwise coprime.
+func CRT(residues, moduli []*big.Int) (*big.Int, error) {
+	if len(residues) != len(moduli) || len(moduli) == 0 {
+		return nil, fmt.Errorf("CRT requires as many residues as moduli")
+	}
+	product := big.NewInt(1)
+	for _, m := range moduli {
+		product.Mul(product, m)
+	}
+	x := big.NewInt(0)
+	for i, m := range moduli {
+		// ms is the product of the other moduli
+		ms := new(big.Int).Div(product, m)
+		inv, err := InvMod(ms, m)
+		if err != nil {
+			return nil, fmt.Errorf("moduli are not pairwise coprime: %v", err)
+		}
+		term := new(big.Int).Mul(residues[i], ms)
+		term.Mul(term, inv)
+		x.Add(x, term)
+	}
+	return x.Mod(x, product), nil
+}
+
+// NthRoot returns the integer nth root of x, rounded down, and whether it is
+// exact. x must be non negative and n positive, nil is returned otherwise.
+func NthRoot(x *big.Int, n int) (*big.Int, bool) {
+	if x.Sign() < 0 || n < 1 {
+		return nil, false
+	}
+	if x.Cmp(big.NewInt(2)) < 0 || n == 1 {
+		return new(big.Int).Set(x), true
+	}
+	bigN := big.NewInt(int64(n))
+	nMinusOne := big.NewInt(int64(n - 1))
+	// start above the root and use Newton's method until it stops decreasing
+	r := new(big.Int).Lsh(big.NewInt(1), uint((x.BitLen()+n-1)/n))
+	for {
+		// next = ((n-1) * r + x / r^(n-1)) / n
+		next := new(big.Int).Exp(r, nMinusOne, nil)
+		next.Div(x, next)
+		next.Add(next, new(big.Int).Mul(nMinusOne, r))
+		next.Div(next, bigN)
+		if next.Cmp(r) >= 0 {
+			break
+		}
+		r = next
+	}
+	return r, new(big.Int).Exp(r, bigN, nil).Cmp(x) == 0
+}
+
+// RSABroadcastAttack recovers the msg encrypted under e public keys using
+// the same small exponent e: by CRT m^e is known modulo the product of the
+// moduli, which is larger than m^e, so its eth root is m.
+func RSABroadcastAttack(encryptedMsgs []*big.Int, keys []*RSAPublicKey) (*big.Int, error) {
+	if len(encryptedMsgs) != len(keys) || len(keys) == 0 {
+		return nil, fmt.Errorf("the attack requires one key per encrypted msg")
+	}
+	e := keys[0].E
+	if !e.IsInt64() || e.Int64() > int64(len(keys)) {
+		return nil, fmt.Errorf("the attack requires at least e = %v encrypted msgs", e)
+	}
+	moduli := make([]*big.Int, len(keys))
+	for i, k := range keys {
+		if k.E.Cmp(e) != 0 {
+			return nil, fmt.Errorf("all the keys must use the same exponent")
+		}
+		moduli[i] = k.N
+	}
+	c, err := CRT(encryptedMsgs, moduli)
+	if err != nil {
+		return nil, err
+	}
+	m, exact := NthRoot(c, int(e.Int64()))
+	if !exact {
+		return nil, fmt.Errorf("m^%v is not a perfect power", e)
+	}
+	return m, nil
+}
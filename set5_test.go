@@ -0,0 +1,461 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	mrand "math/rand"
+	"net"
+	"strings"
+	"testing"
+)
+
+func Test_Challenge33_ImplementDiffieHellman(t *testing.T) {
+	t.Run("Small numbers", func(t *testing.T) {
+		g := &DHGroup{P: big.NewInt(37), G: big.NewInt(5)}
+		a, err := g.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := g.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s1, s2 := a.SharedSecret(b.Public), b.SharedSecret(a.Public); s1.Cmp(s2) != 0 {
+			t.Fatalf("shared secrets differ %v != %v", s1, s2)
+		}
+	})
+
+	g := NISTDHGroup()
+	if !g.P.ProbablyPrime(20) {
+		t.Fatal("NIST p must be prime")
+	}
+	a, err := g.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := g.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s1, s2 := a.SharedSecret(b.Public), b.SharedSecret(a.Public)
+	if s1.Cmp(s2) != 0 {
+		t.Fatalf("shared secrets differ %v != %v", s1, s2)
+	}
+	fmt.Printf("shared secret = %x\n", s1)
+
+	t.Run("Derive AES keys", func(t *testing.T) {
+		if k := DHAESKeySHA1(s1); len(k) != 16 {
+			t.Fatalf("len(key) = %d", len(k))
+		}
+		if k1, k2 := DHAESKeySHA256(s1), DHAESKeySHA256(s2); len(k1) != 16 || !bytes.Equal(k1, k2) {
+			t.Fatalf("got = %x ; expected = %x", k1, k2)
+		}
+	})
+	t.Run("Encrypt with the negotiated key", func(t *testing.T) {
+		msg := []byte("YELLOW SUBMARINE")
+		data, err := CBCEncryptWithIV(msg, DHAESKeySHA1(s1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := CBCDecryptWithIV(data, DHAESKeySHA1(s2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, msg) {
+			t.Fatalf("got = %q ; expected = %q", got, msg)
+		}
+	})
+}
+
+func Test_Challenge34_DHMITMKeyFixingAttack(t *testing.T) {
+	msg := []byte("YELLOW SUBMARINE")
+	t.Run("Echo", func(t *testing.T) {
+		echo, err := RunDHEcho(NISTDHGroup(), msg, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(echo, msg) {
+			t.Fatalf("got = %q ; expected = %q", echo, msg)
+		}
+	})
+	t.Run("Key fixing", func(t *testing.T) {
+		mallory := &DHKeyFixingMITM{}
+		echo, err := RunDHEcho(NISTDHGroup(), msg, mallory.Intercept)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(echo, msg) {
+			t.Fatalf("got = %q ; expected = %q", echo, msg)
+		}
+		if mallory.Err != nil {
+			t.Fatal("Mallory could not decrypt", mallory.Err)
+		}
+		if len(mallory.Msgs) != 2 {
+			t.Fatalf("Mallory decrypted %d msgs ; expected 2", len(mallory.Msgs))
+		}
+		for _, m := range mallory.Msgs {
+			fmt.Printf("Mallory decrypted %q\n", m)
+			if !bytes.Equal(m, msg) {
+				t.Fatalf("got = %q ; expected = %q", m, msg)
+			}
+		}
+	})
+	t.Run("Invalid msgs", func(t *testing.T) {
+		for name, mitm := range invalidDHMITMs() {
+			if _, err := RunDHEcho(NISTDHGroup(), msg, mitm); err == nil {
+				t.Fatalf("%s: the protocol must fail", name)
+			}
+			if _, err := RunDHNegotiatedEcho(NISTDHGroup(), msg, mitm); err == nil {
+				t.Fatalf("%s: the negotiated protocol must fail", name)
+			}
+		}
+	})
+}
+
+// invalidDHMITMs returns MITMs tampering with the fields of the DH msgs
+func invalidDHMITMs() map[string]DHMITM {
+	return map[string]DHMITM{
+		"p = 2": func(msg DHMessage, fromA bool) DHMessage {
+			if msg.P != nil {
+				msg.P = big.NewInt(2)
+			}
+			return msg
+		},
+		"no p": func(msg DHMessage, fromA bool) DHMessage {
+			msg.P = nil
+			return msg
+		},
+		"no g": func(msg DHMessage, fromA bool) DHMessage {
+			msg.G = nil
+			return msg
+		},
+		"no public key": func(msg DHMessage, fromA bool) DHMessage {
+			msg.Public = nil
+			return msg
+		},
+	}
+}
+
+func Test_Challenge35_DHMITMWithMaliciousG(t *testing.T) {
+	msg := []byte("YELLOW SUBMARINE")
+	t.Run("Echo", func(t *testing.T) {
+		echo, err := RunDHNegotiatedEcho(NISTDHGroup(), msg, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(echo, msg) {
+			t.Fatalf("got = %q ; expected = %q", echo, msg)
+		}
+	})
+
+	cases := []struct {
+		name string
+		g    func(p *big.Int) *big.Int
+	}{
+		{name: "g = 1", g: func(p *big.Int) *big.Int { return big.NewInt(1) }},
+		{name: "g = p", g: func(p *big.Int) *big.Int { return new(big.Int).Set(p) }},
+		{name: "g = p-1", g: func(p *big.Int) *big.Int { return new(big.Int).Sub(p, big.NewInt(1)) }},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// run several times to cover the parities of the private keys
+			for i := 0; i < 8; i++ {
+				mallory := &DHMaliciousGMITM{G: c.g}
+				echo, err := RunDHNegotiatedEcho(NISTDHGroup(), msg, mallory.Intercept)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(echo, msg) {
+					t.Fatalf("got = %q ; expected = %q", echo, msg)
+				}
+				if mallory.Err != nil {
+					t.Fatal("Mallory could not decrypt", mallory.Err)
+				}
+				if len(mallory.Msgs) != 2 {
+					t.Fatalf("Mallory decrypted %d msgs ; expected 2", len(mallory.Msgs))
+				}
+				for _, m := range mallory.Msgs {
+					if !bytes.Equal(m, msg) {
+						t.Fatalf("got = %q ; expected = %q", m, msg)
+					}
+				}
+			}
+		})
+	}
+}
+
+// runSRPLogin runs server.Serve and login on both ends of a net.Pipe and
+// returns the error of each side.
+func runSRPLogin(serve func(io.ReadWriter) error, login func(io.ReadWriter) error) (error, error) {
+	serverConn, clientConn := net.Pipe()
+	errc := make(chan error, 1)
+	go func() {
+		defer serverConn.Close()
+		errc <- serve(serverConn)
+	}()
+	clientErr := login(clientConn)
+	clientConn.Close()
+	return <-errc, clientErr
+}
+
+func Test_Challenge36_ImplementSecureRemotePassword(t *testing.T) {
+	params := NewSRPParams()
+	server := NewSRPServer(params)
+	email, password := "foo@bar.com", "YELLOW SUBMARINE"
+	if err := server.Register(email, password); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Valid password", func(t *testing.T) {
+		serverErr, clientErr := runSRPLogin(server.Serve, NewSRPClient(params, email, password).Login)
+		if serverErr != nil || clientErr != nil {
+			t.Fatalf("Login failed, server: %v ; client: %v", serverErr, clientErr)
+		}
+	})
+	t.Run("Wrong password", func(t *testing.T) {
+		serverErr, clientErr := runSRPLogin(server.Serve, NewSRPClient(params, email, "ORANGE SUBMARINE").Login)
+		if !errors.Is(serverErr, ErrSRPAuthentication) || !errors.Is(clientErr, ErrSRPAuthentication) {
+			t.Fatalf("Login must fail, server: %v ; client: %v", serverErr, clientErr)
+		}
+	})
+	t.Run("Unknown user", func(t *testing.T) {
+		serverErr, clientErr := runSRPLogin(server.Serve, NewSRPClient(params, "bar@foo.com", password).Login)
+		if !errors.Is(serverErr, ErrSRPAuthentication) || !errors.Is(clientErr, ErrSRPAuthentication) {
+			t.Fatalf("Login must fail, server: %v ; client: %v", serverErr, clientErr)
+		}
+	})
+}
+
+func Test_Challenge37_BreakSRPWithZeroKey(t *testing.T) {
+	params := NewSRPParams()
+	server := NewSRPServer(params)
+	email := "foo@bar.com"
+	password, err := GenerateRandomBytes(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Register(email, string(password)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, multiple := range []int64{0, 1, 2, 3} {
+		t.Run(fmt.Sprintf("A = %d * N", multiple), func(t *testing.T) {
+			client := NewSRPZeroKeyClient(params, email, multiple)
+			serverErr, clientErr := runSRPLogin(server.Serve, client.Login)
+			if serverErr != nil || clientErr != nil {
+				t.Fatalf("Login without password failed, server: %v ; client: %v", serverErr, clientErr)
+			}
+		})
+	}
+}
+
+func Test_Challenge38_OfflineDictionaryAttackOnSimplifiedSRP(t *testing.T) {
+	data, err := ioutil.ReadFile("data/challenge-data-38.txt")
+	if err != nil {
+		t.Fatal("Could not read the wordlist", err)
+	}
+	wordlist := strings.Fields(string(data))
+	params := NewSRPParams()
+	email, password := "foo@bar.com", wordlist[mrand.Intn(len(wordlist))]
+
+	t.Run("Simplified SRP", func(t *testing.T) {
+		server := NewSimpleSRPServer(params)
+		if err := server.Register(email, password); err != nil {
+			t.Fatal(err)
+		}
+		serverErr, clientErr := runSRPLogin(server.Serve, NewSimpleSRPClient(params, email, password).Login)
+		if serverErr != nil || clientErr != nil {
+			t.Fatalf("Login failed, server: %v ; client: %v", serverErr, clientErr)
+		}
+		serverErr, clientErr = runSRPLogin(server.Serve, NewSimpleSRPClient(params, email, "YELLOW SUBMARINE").Login)
+		if !errors.Is(serverErr, ErrSRPAuthentication) || !errors.Is(clientErr, ErrSRPAuthentication) {
+			t.Fatalf("Login must fail, server: %v ; client: %v", serverErr, clientErr)
+		}
+		// the full SRP server does not send u
+		fullServer := NewSRPServer(params)
+		if err := fullServer.Register(email, password); err != nil {
+			t.Fatal(err)
+		}
+		_, clientErr = runSRPLogin(fullServer.Serve, NewSimpleSRPClient(params, email, password).Login)
+		if !errors.Is(clientErr, ErrSRPProtocol) {
+			t.Fatalf("Login without u must fail with a protocol error, got %v", clientErr)
+		}
+	})
+	t.Run("Crack the password", func(t *testing.T) {
+		mallory, err := NewSimpleSRPMITM(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serverErr, _ := runSRPLogin(mallory.Serve, NewSimpleSRPClient(params, email, password).Login)
+		if serverErr != nil {
+			t.Fatal("Mallory could not capture the proof", serverErr)
+		}
+		got, err := mallory.Crack(wordlist, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Println("cracked password =", got)
+		if got != password {
+			t.Fatalf("got = %q ; expected = %q", got, password)
+		}
+		if _, err := mallory.Crack(wordlist, 0); err == nil {
+			t.Fatal("Crack must reject 0 workers")
+		}
+	})
+	t.Run("Password not in the wordlist", func(t *testing.T) {
+		mallory, err := NewSimpleSRPMITM(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		runSRPLogin(mallory.Serve, NewSimpleSRPClient(params, email, "YELLOW SUBMARINE").Login)
+		if _, err := mallory.Crack(wordlist, 4); err == nil {
+			t.Fatal("password must not be found")
+		}
+	})
+}
+
+func Test_Challenge39_ImplementRSA(t *testing.T) {
+	t.Run("invmod", func(t *testing.T) {
+		got, err := InvMod(big.NewInt(17), big.NewInt(3120))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Cmp(big.NewInt(2753)) != 0 {
+			t.Fatalf("got = %v ; expected = 2753", got)
+		}
+		for i := 0; i < 100; i++ {
+			m := big.NewInt(mrand.Int63n(1<<40) + 2)
+			a := big.NewInt(mrand.Int63())
+			got, err := InvMod(a, m)
+			expected := new(big.Int).ModInverse(a, m)
+			if expected == nil {
+				if err == nil {
+					t.Fatalf("%v is not invertible modulo %v", a, m)
+				}
+				continue
+			}
+			if err != nil || got.Cmp(expected) != 0 {
+				t.Fatalf("invmod(%v, %v) got = %v (%v) ; expected = %v", a, m, got, err, expected)
+			}
+		}
+		if _, err := InvMod(big.NewInt(6), big.NewInt(9)); err == nil {
+			t.Fatal("6 is not invertible modulo 9")
+		}
+	})
+	t.Run("Encrypt and decrypt", func(t *testing.T) {
+		key, err := GenerateRSAKey(1024, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key.E.Cmp(big.NewInt(3)) != 0 || key.N.BitLen() != 1024 {
+			t.Fatalf("unexpected key e = %v ; len(n) = %d bits", key.E, key.N.BitLen())
+		}
+		m := big.NewInt(42)
+		if got := key.Decrypt(key.Encrypt(m)); got.Cmp(m) != 0 {
+			t.Fatalf("got = %v ; expected = %v", got, m)
+		}
+		msg := "YELLOW SUBMARINE"
+		got := IntToString(key.Decrypt(key.Encrypt(StringToInt(msg))))
+		fmt.Println("decrypted =", got)
+		if got != msg {
+			t.Fatalf("got = %q ; expected = %q", got, msg)
+		}
+	})
+	t.Run("Invalid e", func(t *testing.T) {
+		for _, e := range []int64{-3, 0, 1, 2, 4, 65536} {
+			if _, err := GenerateRSAKey(64, e); err == nil {
+				t.Fatalf("e = %d must be rejected", e)
+			}
+		}
+	})
+}
+
+func Test_Challenge40_ImplementE3RSABroadcastAttack(t *testing.T) {
+	t.Run("CRT", func(t *testing.T) {
+		x, err := CRT(
+			[]*big.Int{big.NewInt(2), big.NewInt(3), big.NewInt(2)},
+			[]*big.Int{big.NewInt(3), big.NewInt(5), big.NewInt(7)},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if x.Cmp(big.NewInt(23)) != 0 {
+			t.Fatalf("got = %v ; expected = 23", x)
+		}
+		if _, err := CRT([]*big.Int{big.NewInt(1), big.NewInt(1)}, []*big.Int{big.NewInt(4), big.NewInt(6)}); err == nil {
+			t.Fatal("moduli 4 and 6 are not coprime")
+		}
+	})
+	t.Run("NthRoot", func(t *testing.T) {
+		for _, c := range []struct {
+			x        int64
+			n        int
+			root     int64
+			expected bool
+		}{
+			{x: 0, n: 3, root: 0, expected: true},
+			{x: 1, n: 3, root: 1, expected: true},
+			{x: 8, n: 3, root: 2, expected: true},
+			{x: 26, n: 3, root: 2, expected: false},
+			{x: 27, n: 3, root: 3, expected: true},
+			{x: 1 << 40, n: 4, root: 1 << 10, expected: true},
+			{x: 99, n: 2, root: 9, expected: false},
+		} {
+			root, exact := NthRoot(big.NewInt(c.x), c.n)
+			if root.Cmp(big.NewInt(c.root)) != 0 || exact != c.expected {
+				t.Fatalf("NthRoot(%d, %d) got = %v, %v ; expected = %d, %v", c.x, c.n, root, exact, c.root, c.expected)
+			}
+		}
+		m, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 1000))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cube := new(big.Int).Exp(m, big.NewInt(3), nil)
+		if root, exact := NthRoot(cube, 3); !exact || root.Cmp(m) != 0 {
+			t.Fatalf("got = %v ; expected = %v", root, m)
+		}
+		if root, exact := NthRoot(cube.Add(cube, big.NewInt(1)), 3); exact || root.Cmp(m) != 0 {
+			t.Fatalf("got = %v ; expected = %v", root, m)
+		}
+	})
+	t.Run("Broadcast attack", func(t *testing.T) {
+		// m^3 is larger than each modulus but smaller than their product
+		msg := strings.Repeat("YELLOW SUBMARINE", 6) + "YELL"
+		m := StringToInt(msg)
+		m3 := new(big.Int).Exp(m, big.NewInt(3), nil)
+		var (
+			keys          []*RSAPublicKey
+			encryptedMsgs []*big.Int
+		)
+		for i := 0; i < 3; i++ {
+			key, err := GenerateRSAKey(1024, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m3.Cmp(key.N) <= 0 {
+				t.Fatal("m^3 must wrap around the modulus")
+			}
+			keys = append(keys, &key.RSAPublicKey)
+			encryptedMsgs = append(encryptedMsgs, key.Encrypt(m))
+		}
+		if _, exact := NthRoot(encryptedMsgs[0], 3); exact {
+			t.Fatal("A single encrypted msg must not be a cube")
+		}
+		if _, err := RSABroadcastAttack(encryptedMsgs[:1], keys[:1]); err == nil {
+			t.Fatal("The attack requires 3 encrypted msgs")
+		}
+		got, err := RSABroadcastAttack(encryptedMsgs, keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Println("decrypted =", IntToString(got))
+		if IntToString(got) != msg {
+			t.Fatalf("got = %q ; expected = %q", IntToString(got), msg)
+		}
+	})
+}